@@ -7,11 +7,12 @@ import (
 	"errors"
 	"fmt"
 	"github.com/mgranderath/dnsperf/metrics"
-	"github.com/mgranderath/dnsperf/util"
+	"github.com/miekg/dns"
 	"golang.org/x/net/http2"
 	"log"
 	"net"
 	"net/url"
+	"sync"
 	"time"
 	"unsafe"
 )
@@ -30,34 +31,145 @@ type baseClient struct {
 	resolvedConfig    *tls.Config
 	resolvedAddresses []string
 
+	// authenticator enforces the usage profile of TLSOptions, it is nil without one
+	authenticator *authenticator
+
+	// bootstrap resolves the host of URL, it is nil if the addresses are known up front
+	bootstrap *bootstrapper
+	port      string
+
 	options Options
 }
 
+// collectorHolder tracks the collector of the exchange that is currently using a connection,
+// so that events of a connection reused across exchanges are recorded by the right exchange
+type collectorHolder struct {
+	mutex     sync.Mutex
+	collector *metrics.Collector
+}
+
+func newCollectorHolder(collector *metrics.Collector) *collectorHolder {
+	return &collectorHolder{collector: collector}
+}
+
+func (h *collectorHolder) get() *metrics.Collector {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.collector
+}
+
+func (h *collectorHolder) set(collector *metrics.Collector) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.collector = collector
+}
+
+// staleConnectionProbe bounds the read that checks whether a kept connection is still open
+const staleConnectionProbe = time.Millisecond
+
+// reusableConn keeps a stream connection open between exchanges when Options.ReuseConnection is set
+type reusableConn struct {
+	mutex sync.Mutex
+	conn  net.Conn
+	reuse bool
+}
+
+// lock serializes exchanges that share the connection, it is a no-op if connections are not reused
+func (r *reusableConn) lock() func() {
+	if !r.reuse {
+		return func() {}
+	}
+	r.mutex.Lock()
+	return r.mutex.Unlock
+}
+
+// discardStale closes the kept connection if the server closed it or sent data while it was idle, get dials a new
+// connection then. It is called before the exchange starts so that the probe is not part of the measurement.
+func (r *reusableConn) discardStale(collector *metrics.Collector) {
+	if r.conn == nil || connAlive(r.conn) {
+		return
+	}
+	_ = r.conn.Close()
+	r.conn = nil
+	collector.ConnectionRedialed()
+}
+
+// connAlive probes an idle connection with a short read: a connection the server closed returns EOF or an error
+// right away, a usable one times out as the server sends nothing without a query
+func connAlive(conn net.Conn) bool {
+	if err := conn.SetReadDeadline(time.Now().Add(staleConnectionProbe)); err != nil {
+		return false
+	}
+	defer conn.SetReadDeadline(time.Time{})
+
+	var b [1]byte
+	n, err := conn.Read(b[:])
+	var netErr net.Error
+	return n == 0 && errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// get returns the kept connection or dials a new one
+func (r *reusableConn) get(dial func() (net.Conn, error), collector *metrics.Collector) (net.Conn, error) {
+	if r.conn != nil {
+		collector.ConnectionReused()
+		collector.RemoteAddress(r.conn.RemoteAddr().String())
+		return r.conn, nil
+	}
+
+	conn, err := dial()
+	if err != nil {
+		return nil, err
+	}
+	if r.reuse {
+		r.conn = conn
+	}
+	return conn, nil
+}
+
+// release closes the connection unless it is kept for the next exchange, failed connections are never kept
+func (r *reusableConn) release(conn net.Conn, failed bool) {
+	if r.reuse && !failed {
+		return
+	}
+	_ = conn.Close()
+	if r.conn == conn {
+		r.conn = nil
+	}
+}
+
 func newBaseClient(upsURL *url.URL, options Options) (*baseClient, error) {
 	host, port, err := net.SplitHostPort(upsURL.Host)
 	if err != nil {
 		return nil, fmt.Errorf("baseClient requires port in address %s", upsURL.String())
 	}
 
-	var resolverAddresses []string
+	if options.DialPolicy == DialHappyEyeballs && options.QuicOptions != nil && options.QuicOptions.LocalPort != 0 &&
+		(upsURL.Scheme == "quic" || upsURL.Scheme == "h3") {
+		return nil, errors.New("QuicOptions.LocalPort can't be used with DialHappyEyeballs, the connection attempts would bind the same port")
+	}
+
+	c := &baseClient{
+		URL:     upsURL,
+		options: options,
+		port:    port,
+	}
+
+	// the host is resolved by the first exchange, so that the time it takes is measured
 	if len(options.ServerIPAddrs) != 0 {
-		for _, ip := range options.ServerIPAddrs {
-			addr := net.JoinHostPort(ip.String(), port)
-			resolverAddresses = append(resolverAddresses, addr)
-		}
+		c.resolvedAddresses = joinAddresses(options.ServerIPAddrs, port)
+	} else if ip := net.ParseIP(host); ip != nil {
+		c.resolvedAddresses = joinAddresses([]net.IP{ip}, port)
 	} else {
-		resolverAddresses, err = util.ResolveURLToIP(upsURL)
+		c.bootstrap, err = newBootstrapper(host, options)
 		if err != nil {
 			return nil, err
 		}
 	}
 
-	c := &baseClient{
-		URL:               upsURL,
-		options:           options,
-		resolvedAddresses: resolverAddresses,
+	c.authenticator, err = newAuthenticator(options.TLSOptions, upsURL)
+	if err != nil {
+		return nil, err
 	}
-
 	c.resolvedConfig = c.getTLSConfig(host)
 
 	// if the caller supplied a TLS session cache, use it
@@ -68,42 +180,161 @@ func newBaseClient(upsURL *url.URL, options Options) (*baseClient, error) {
 	return c, nil
 }
 
+func joinAddresses(ips []net.IP, port string) []string {
+	addrs := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		addrs = append(addrs, net.JoinHostPort(ip.String(), port))
+	}
+	return addrs
+}
+
+// upstreamAddresses returns the addresses of the upstream, its host is resolved if it is not cached
+func (c *baseClient) upstreamAddresses(ctx context.Context, collector *metrics.Collector) ([]string, error) {
+	if c.bootstrap == nil {
+		return c.resolvedAddresses, nil
+	}
+	ips, err := c.bootstrap.resolve(ctx, collector)
+	if err != nil {
+		return nil, err
+	}
+	return joinAddresses(ips, c.port), nil
+}
+
+// dialFailed makes the next exchange resolve the host again after no address could be connected to
+func (c *baseClient) dialFailed(ctx context.Context) {
+	if c.bootstrap != nil && ctx.Err() == nil {
+		c.bootstrap.invalidate()
+	}
+}
+
+// getDeadline returns the earlier of the context deadline and the configured timeout, the zero time means no deadline
+func (c *baseClient) getDeadline(ctx context.Context) time.Time {
+	var deadline time.Time
+	if c.options.Timeout > 0 {
+		deadline = time.Now().Add(c.options.Timeout)
+	}
+	if ctxDeadline, ok := ctx.Deadline(); ok && (deadline.IsZero() || ctxDeadline.Before(deadline)) {
+		deadline = ctxDeadline
+	}
+	return deadline
+}
+
+// deadliner is implemented by connections and QUIC streams
+type deadliner interface {
+	SetDeadline(t time.Time) error
+}
+
+// watchContext interrupts blocking I/O on conn once ctx is done, the returned function stops watching
+func watchContext(ctx context.Context, conn deadliner) func() {
+	if ctx.Done() == nil {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			// a deadline in the past makes pending reads and writes fail immediately
+			_ = conn.SetDeadline(time.Unix(1, 0))
+		case <-done:
+		}
+	}()
+	return func() { close(done) }
+}
+
+// handleContextError records whether a failed exchange was cancelled or ran into a timeout
+func handleContextError(ctx context.Context, err error, collector *metrics.Collector) {
+	if err == nil {
+		return
+	}
+
+	var netErr net.Error
+	switch {
+	case errors.Is(ctx.Err(), context.Canceled) || errors.Is(err, context.Canceled):
+		collector.Cancelled()
+	case errors.Is(ctx.Err(), context.DeadlineExceeded) || errors.Is(err, context.DeadlineExceeded):
+		collector.TimedOut()
+	case errors.As(err, &netErr) && netErr.Timeout():
+		collector.TimedOut()
+	}
+}
+
+// writeMsg sends m over cn and records its size
+func writeMsg(cn *dns.Conn, m *dns.Msg, collector *metrics.Collector) error {
+	buf, err := m.Pack()
+	if err != nil {
+		return err
+	}
+	collector.QuerySize(len(buf))
+	_, err = cn.Write(buf)
+	return err
+}
+
+// readMsg reads a message from cn and records its size
+func readMsg(cn *dns.Conn, collector *metrics.Collector) (*dns.Msg, error) {
+	buf, err := cn.ReadMsgHeader(nil)
+	if err != nil {
+		return nil, err
+	}
+	collector.ResponseSize(len(buf))
+
+	m := new(dns.Msg)
+	if err := m.Unpack(buf); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 func (c *baseClient) handleTLSError(err error, collector *metrics.Collector) {
 	x509error := &x509.CertificateInvalidError{}
 	converted := errors.As(err, x509error)
 	if converted {
 		collector.TLSError(x509error.Reason)
 	}
+
+	if alert, ok := tlsAlert(err); ok {
+		collector.TLSAlert(alert)
+	}
 }
 
 func (c *baseClient) getTLSDialContext(collector *metrics.Collector) dialHandler {
-	return func(context context.Context, network string, addr string) (net.Conn, error) {
-		tlsConfig := c.resolvedConfig
+	return func(ctx context.Context, network string, addr string) (net.Conn, error) {
+		c.lookupTLSA(ctx, collector)
+		tickets := &tlsTicketReader{}
+		tlsConfig := c.getConnectionTLSConfig(c.recordSessionTickets(tickets.config(c.resolvedConfig), tickets, collector), collector)
 		dialContext := c.getDialContext(collector)
 
-		rawConn, err := dialContext(context, "tcp", "")
+		rawConn, err := dialContext(ctx, "tcp", "")
 		if err != nil {
 			return nil, err
 		}
+		tickets.Conn = rawConn
 
-		// we want the timeout to cover the whole process: TCP connection and TLS handshake
-		// dialTimeout will be used as connection deadLine
-		conn := tls.Client(rawConn, tlsConfig)
-		err = conn.SetDeadline(time.Now().Add(dialTimeout))
+		// the handshake is bound by Options.Timeout and the deadline of ctx, dialTimeout is used if neither is set
+		conn := tls.Client(&handshakeTimelineConn{Conn: tickets, collector: collector}, tlsConfig)
+		deadline := c.getDeadline(ctx)
+		if deadline.IsZero() {
+			deadline = time.Now().Add(dialTimeout)
+		}
+		err = conn.SetDeadline(deadline)
 		if err != nil {
 			log.Printf("DeadLine is not supported cause: %s", err)
 			conn.Close()
 			return nil, err
 		}
 
+		stopWatching := watchContext(ctx, conn)
 		collector.TLSHandshakeStart()
 		err = conn.Handshake()
 		collector.TLSHandshakeFinished(conn.ConnectionState().Version)
+		stopWatching()
 		if err != nil {
 			conn.Close()
 			c.handleTLSError(err, collector)
 			return nil, err
 		}
+		collector.HandshakeEvent(metrics.HandshakeEventTLSFinished)
+		c.recordTLSState(conn.ConnectionState(), collector)
 
 		return conn, nil
 	}
@@ -117,10 +348,7 @@ func (c *baseClient) getDialContext(collector *metrics.Collector) (dialContext d
 		Timeout: c.options.Timeout,
 	}
 
-	start := func(network string) {
-		if collector == nil {
-			return
-		}
+	start := func(collector *metrics.Collector, network string) {
 		switch network {
 		case "tcp":
 			collector.TCPHandshakeStart()
@@ -129,10 +357,7 @@ func (c *baseClient) getDialContext(collector *metrics.Collector) (dialContext d
 		}
 	}
 
-	stop := func(network string) {
-		if collector == nil {
-			return
-		}
+	stop := func(collector *metrics.Collector, network string) {
 		switch network {
 		case "tcp":
 			collector.TCPHandshakeFinished()
@@ -142,21 +367,41 @@ func (c *baseClient) getDialContext(collector *metrics.Collector) (dialContext d
 	}
 
 	return func(ctx context.Context, network, addr string) (net.Conn, error) {
-		var dialError error
-
-		// Return first connection without error
 		// Note that we're using bootstrapped resolverAddress instead of what's passed to the function
-		for _, resolverAddress := range c.resolvedAddresses {
-			start(network)
-			con, err := dialer.DialContext(ctx, network, resolverAddress)
-			if err == nil {
-				stop(network)
-				return con, err
-			}
-			dialError = err
+		addrs, err := c.dialAddresses(ctx, collector)
+		if err != nil {
+			return nil, err
 		}
 
-		return nil, dialError
+		// every attempt records into its own collector, only the one of the attempt that won is kept
+		attempts := make([]*metrics.Collector, len(addrs))
+		for i := range attempts {
+			attempts[i] = metrics.NewCollector()
+		}
+
+		// connecting a UDP socket does not send anything, so the first attempt would always win a race
+		index, con, failed, err := c.raceAttempts(ctx, addrs, network != "udp", func(ctx context.Context, i int) (interface{}, error) {
+			start(attempts[i], network)
+			con, err := dialer.DialContext(ctx, network, addrs[i])
+			if err != nil {
+				return nil, err
+			}
+			stop(attempts[i], network)
+			return con, nil
+		}, func(con interface{}) {
+			_ = con.(net.Conn).Close()
+		})
+
+		if collector != nil {
+			collector.DialAttemptsFailed(failed)
+			collector.AdoptConnectionAttempt(attempts[index])
+			collector.RemoteAddress(addrs[index])
+		}
+		if err != nil {
+			c.dialFailed(ctx)
+			return nil, err
+		}
+		return con.(net.Conn), nil
 	}
 }
 
@@ -207,6 +452,12 @@ func (c *baseClient) getTLSConfig(host string) *tls.Config {
 		if c.options.TLSOptions.SkipCommonName {
 			tlsConfig.VerifyPeerCertificate = c.skipHostnameVerification
 		}
+
+		// the usage profile authenticates the server on its own, see getConnectionTLSConfig
+		if c.authenticator != nil {
+			tlsConfig.InsecureSkipVerify = true
+			tlsConfig.VerifyPeerCertificate = nil
+		}
 	}
 
 	// The supported application level protocols should be specified only
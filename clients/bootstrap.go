@@ -0,0 +1,169 @@
+package clients
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/joomcode/errorx"
+	"github.com/mgranderath/dnsperf/metrics"
+	"github.com/miekg/dns"
+	"net"
+	"sync"
+	"time"
+)
+
+// SystemBootstrap is the bootstrap source of addresses resolved by the system resolver
+const SystemBootstrap = "system"
+
+// bootstrapper resolves the host of an upstream with the bootstrap upstreams of Options.Bootstrap, or the system
+// resolver if there are none. The addresses are cached until the smallest TTL of the answers expires or until
+// dialing them failed. The system resolver does not report TTLs, its addresses are kept until dialing them failed.
+type bootstrapper struct {
+	host    string
+	clients []DnsClient
+	sources []string
+
+	mutex   sync.Mutex
+	ips     []net.IP
+	expires time.Time
+}
+
+func newBootstrapper(host string, options Options) (*bootstrapper, error) {
+	b := &bootstrapper{host: host}
+
+	// bootstrap upstreams are used on their own, their host must be an IP address or is resolved by the system
+	bootstrapOptions := Options{Timeout: options.Timeout}
+	for _, address := range options.Bootstrap {
+		client, err := AddressToClient(address, bootstrapOptions)
+		if err != nil {
+			return nil, errorx.Decorate(err, "invalid bootstrap upstream %s", address)
+		}
+		b.clients = append(b.clients, client)
+		b.sources = append(b.sources, address)
+	}
+	return b, nil
+}
+
+// resolve returns the cached addresses of the host or looks them up, the lookup is recorded by collector
+func (b *bootstrapper) resolve(ctx context.Context, collector *metrics.Collector) ([]net.IP, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.ips != nil && (b.expires.IsZero() || time.Now().Before(b.expires)) {
+		return b.ips, nil
+	}
+
+	if collector != nil {
+		collector.BootstrapStart()
+	}
+	ips, ttl, source, err := b.lookup(ctx)
+	if err != nil {
+		if collector != nil {
+			collector.BootstrapFailed()
+		}
+		return nil, errorx.Decorate(err, "failed to lookup %s", b.host)
+	}
+	if collector != nil {
+		collector.BootstrapDone(source)
+	}
+
+	b.ips = ips
+	b.expires = time.Time{}
+	if source != SystemBootstrap {
+		b.expires = time.Now().Add(time.Duration(ttl) * time.Second)
+	}
+	return ips, nil
+}
+
+// invalidate drops the cached addresses, so that the next exchange resolves the host again
+func (b *bootstrapper) invalidate() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.ips = nil
+}
+
+// lookup asks the bootstrap upstreams in order until one returns an address, it returns the addresses together
+// with their smallest TTL and the bootstrap upstream that answered
+func (b *bootstrapper) lookup(ctx context.Context) ([]net.IP, uint32, string, error) {
+	if len(b.clients) == 0 {
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, b.host)
+		if err != nil {
+			return nil, 0, "", err
+		}
+		ips := make([]net.IP, 0, len(addrs))
+		for _, addr := range addrs {
+			ips = append(ips, addr.IP)
+		}
+		return ips, 0, SystemBootstrap, nil
+	}
+
+	var lastErr error
+	for i, client := range b.clients {
+		ips, ttl, err := b.lookupUpstream(ctx, client)
+		if err == nil {
+			return ips, ttl, b.sources[i], nil
+		}
+		lastErr = fmt.Errorf("bootstrap %s: %w", b.sources[i], err)
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, 0, "", lastErr
+}
+
+// lookupUpstream sends the A and AAAA queries for the host to a bootstrap upstream at the same time
+func (b *bootstrapper) lookupUpstream(ctx context.Context, client DnsClient) ([]net.IP, uint32, error) {
+	qtypes := []uint16{dns.TypeA, dns.TypeAAAA}
+	responses := make([]*metrics.WithResponseOrError, len(qtypes))
+
+	var wg sync.WaitGroup
+	for i, qtype := range qtypes {
+		wg.Add(1)
+		go func(i int, qtype uint16) {
+			defer wg.Done()
+			req := new(dns.Msg)
+			req.SetQuestion(dns.Fqdn(b.host), qtype)
+			responses[i] = client.ExchangeContext(ctx, req)
+		}(i, qtype)
+	}
+	wg.Wait()
+
+	var ips []net.IP
+	var ttl uint32
+	var lookupErr error
+	for i, response := range responses {
+		reply := response.GetResponse()
+		switch {
+		case response.GetError() != nil:
+			lookupErr = response.GetError()
+			continue
+		case reply.Rcode != dns.RcodeSuccess:
+			lookupErr = fmt.Errorf("%s query failed with %s", dns.TypeToString[qtypes[i]], dns.RcodeToString[reply.Rcode])
+			continue
+		}
+
+		for _, rr := range reply.Answer {
+			var ip net.IP
+			switch rr := rr.(type) {
+			case *dns.A:
+				ip = rr.A
+			case *dns.AAAA:
+				ip = rr.AAAA
+			default:
+				continue
+			}
+			if len(ips) == 0 || rr.Header().Ttl < ttl {
+				ttl = rr.Header().Ttl
+			}
+			ips = append(ips, ip)
+		}
+	}
+
+	if len(ips) == 0 {
+		if lookupErr == nil {
+			lookupErr = errors.New("no A or AAAA records")
+		}
+		return nil, 0, lookupErr
+	}
+	return ips, ttl, nil
+}
@@ -1,6 +1,7 @@
 package clients
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/joomcode/errorx"
@@ -11,7 +12,11 @@ import (
 )
 
 type DnsClient interface {
+	// Exchange is ExchangeContext with context.Background()
 	Exchange(m *dns.Msg) *metrics.WithResponseOrError
+
+	// ExchangeContext sends m to the upstream, ctx can be used to cancel the exchange or to bound it with a deadline
+	ExchangeContext(ctx context.Context, m *dns.Msg) *metrics.WithResponseOrError
 }
 
 func AddressToClient(address string, options Options) (DnsClient, error) {
@@ -38,6 +43,17 @@ func urlToUpstream(upstreamURL *url.URL, options Options) (DnsClient, error) {
 			return nil, errorx.Decorate(err, "couldn't create tls bootstrapper")
 		}
 		return &DoHClient{baseClient: b}, err
+	case "h3":
+		if upstreamURL.Port() == "" {
+			// set default port
+			upstreamURL.Host += ":443"
+		}
+
+		b, err := newBaseClient(upstreamURL, options)
+		if err != nil {
+			return nil, errorx.Decorate(err, "couldn't create tls bootstrapper")
+		}
+		return &DoH3Client{baseClient: b}, err
 	case "tcp":
 		if upstreamURL.Port() == "" {
 			// set default port
@@ -47,7 +63,7 @@ func urlToUpstream(upstreamURL *url.URL, options Options) (DnsClient, error) {
 		if err != nil {
 			return nil, errorx.Decorate(err, "couldn't create tls bootstrapper")
 		}
-		return &DoTCPClient{baseClient: b}, err
+		return &DoTCPClient{baseClient: b, conn: &reusableConn{reuse: options.ReuseConnection}}, err
 	case "udp":
 		if upstreamURL.Port() == "" {
 			// set default port
@@ -66,7 +82,17 @@ func urlToUpstream(upstreamURL *url.URL, options Options) (DnsClient, error) {
 		if err != nil {
 			return nil, errorx.Decorate(err, "couldn't create tls bootstrapper")
 		}
-		return &DoTClient{baseClient: b}, err
+		return &DoTClient{baseClient: b, conn: &reusableConn{reuse: options.ReuseConnection}}, err
+	case "sdns":
+		stamp, err := ParseDNSCryptStamp("sdns://" + upstreamURL.Host)
+		if err != nil {
+			return nil, errorx.Decorate(err, "invalid stamp")
+		}
+		b, err := newBaseClient(&url.URL{Scheme: upstreamURL.Scheme, Host: stamp.ServerAddr}, options)
+		if err != nil {
+			return nil, errorx.Decorate(err, "couldn't create tls bootstrapper")
+		}
+		return &DNSCryptClient{baseClient: b, stamp: stamp}, err
 	case "quic":
 		if upstreamURL.Port() == "" {
 			// https://tools.ietf.org/html/draft-ietf-dprive-dnsoquic-00#section-8.2.1
@@ -0,0 +1,196 @@
+package clients
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/mgranderath/dnsperf/metrics"
+	"github.com/miekg/dns"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TLSA certificate usages (RFC 7218)
+const (
+	tlsaPKIXTA = 0
+	tlsaPKIXEE = 1
+	tlsaDANETA = 2
+	tlsaDANEEE = 3
+)
+
+var (
+	// ErrDANEInsecure is returned when the TLSA response was not validated with DNSSEC by the resolver
+	ErrDANEInsecure = errors.New("TLSA response is not DNSSEC secure")
+
+	// ErrDANENoRecords is returned when the server has no TLSA records
+	ErrDANENoRecords = errors.New("no TLSA records")
+
+	// ErrDANENoMatch is returned when no TLSA record matches the certificate chain
+	ErrDANENoMatch = errors.New("no TLSA record matches the certificate chain")
+)
+
+// daneVerifier authenticates servers with the TLSA records of their authentication domain name (RFC 6698, RFC 7671),
+// the records are cached until their TTL expires
+type daneVerifier struct {
+	client DnsClient
+	name   string
+
+	mutex   sync.Mutex
+	records []*dns.TLSA
+	err     error
+	expires time.Time
+}
+
+// newDANEVerifier authenticates host with its TLSA records for the port and transport (tcp or udp) of the upstream
+func newDANEVerifier(client DnsClient, host string, port string, transport string) (*daneVerifier, error) {
+	if net.ParseIP(host) != nil {
+		return nil, fmt.Errorf("DANE requires a host name instead of the IP address %s", host)
+	}
+	return &daneVerifier{
+		client: client,
+		name:   fmt.Sprintf("_%s._%s.%s", port, transport, dns.Fqdn(host)),
+	}, nil
+}
+
+// lookup queries the TLSA records unless they are cached, the lookup is recorded by collector.
+// Failures are not cached, the next connection looks the records up again.
+func (d *daneVerifier) lookup(ctx context.Context, collector *metrics.Collector) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.err == nil && time.Now().Before(d.expires) {
+		return
+	}
+
+	collector.TLSALookupStart()
+	records, ttl, err := d.query(ctx)
+	collector.TLSALookupDone()
+
+	d.records, d.err = records, err
+	d.expires = time.Now().Add(time.Duration(ttl) * time.Second)
+}
+
+func (d *daneVerifier) query(ctx context.Context) ([]*dns.TLSA, uint32, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(d.name, dns.TypeTLSA)
+	m.SetEdns0(dns.DefaultMsgSize, true)
+	m.AuthenticatedData = true
+
+	result := d.client.ExchangeContext(ctx, m)
+	if result.GetError() != nil {
+		return nil, 0, fmt.Errorf("TLSA lookup of %s failed: %w", d.name, result.GetError())
+	}
+	reply := result.GetResponse()
+	if reply.Rcode != dns.RcodeSuccess && reply.Rcode != dns.RcodeNameError {
+		return nil, 0, fmt.Errorf("TLSA lookup of %s failed with %s", d.name, dns.RcodeToString[reply.Rcode])
+	}
+	if !reply.AuthenticatedData {
+		return nil, 0, ErrDANEInsecure
+	}
+
+	var records []*dns.TLSA
+	var ttl uint32
+	for _, rr := range reply.Answer {
+		if tlsa, ok := rr.(*dns.TLSA); ok {
+			if len(records) == 0 || tlsa.Hdr.Ttl < ttl {
+				ttl = tlsa.Hdr.Ttl
+			}
+			records = append(records, tlsa)
+		}
+	}
+	if len(records) == 0 {
+		return nil, 0, ErrDANENoRecords
+	}
+	return records, ttl, nil
+}
+
+// verify returns the first TLSA record that matches the certificate chain, domainName is checked by the
+// certificate usages that require it
+func (d *daneVerifier) verify(certs []*x509.Certificate, domainName string) (*dns.TLSA, error) {
+	d.mutex.Lock()
+	records, err := d.records, d.err
+	d.mutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if records == nil {
+		return nil, errors.New("TLSA records were not looked up")
+	}
+
+	for _, rr := range records {
+		if matchesTLSARecord(rr, certs, domainName) {
+			return rr, nil
+		}
+	}
+	return nil, ErrDANENoMatch
+}
+
+// matchesTLSARecord applies the certificate usage of rr to the chain as described in RFC 7671 section 5
+func matchesTLSARecord(rr *dns.TLSA, certs []*x509.Certificate, domainName string) bool {
+	switch rr.Usage {
+	case tlsaPKIXTA:
+		chains, err := verifyPKIX(certs, domainName)
+		if err != nil {
+			return false
+		}
+		for _, chain := range chains {
+			for _, cert := range chain[1:] {
+				if matchesTLSACertificate(rr, cert) {
+					return true
+				}
+			}
+		}
+	case tlsaPKIXEE:
+		if _, err := verifyPKIX(certs, domainName); err == nil {
+			return matchesTLSACertificate(rr, certs[0])
+		}
+	case tlsaDANETA:
+		for _, cert := range certs[1:] {
+			if !matchesTLSACertificate(rr, cert) {
+				continue
+			}
+			roots := x509.NewCertPool()
+			roots.AddCert(cert)
+			if _, err := verifyChain(certs, domainName, roots); err == nil {
+				return true
+			}
+		}
+	case tlsaDANEEE:
+		// the name and the validity period of the certificate are not checked (RFC 7671 section 5.1)
+		return matchesTLSACertificate(rr, certs[0])
+	}
+	return false
+}
+
+func matchesTLSACertificate(rr *dns.TLSA, cert *x509.Certificate) bool {
+	hash, err := dns.CertificateToDANE(rr.Selector, rr.MatchingType, cert)
+	return err == nil && strings.EqualFold(hash, rr.Certificate)
+}
+
+// tlsaRecordString formats the usage, selector and matching type of a record like "3 1 1", it is nil without a record
+func tlsaRecordString(rr *dns.TLSA) *string {
+	if rr == nil {
+		return nil
+	}
+	s := fmt.Sprintf("%d %d %d", rr.Usage, rr.Selector, rr.MatchingType)
+	return &s
+}
+
+// daneResult is the DANE result recorded in the metrics
+func daneResult(err error) metrics.DANEResult {
+	switch {
+	case err == nil:
+		return metrics.DANEMatched
+	case errors.Is(err, ErrDANENoMatch):
+		return metrics.DANENoMatch
+	case errors.Is(err, ErrDANENoRecords):
+		return metrics.DANENoRecords
+	case errors.Is(err, ErrDANEInsecure):
+		return metrics.DANEInsecure
+	default:
+		return metrics.DANELookupFailed
+	}
+}
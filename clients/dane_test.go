@@ -0,0 +1,170 @@
+package clients
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"github.com/miekg/dns"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// testChain is a server certificate for dns.example issued by a root
+type testChain struct {
+	root *x509.Certificate
+	leaf *x509.Certificate
+}
+
+func (c *testChain) certs() []*x509.Certificate {
+	return []*x509.Certificate{c.leaf, c.root}
+}
+
+func (c *testChain) roots() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(c.root)
+	return pool
+}
+
+func newTestChain(t *testing.T) *testChain {
+	t.Helper()
+	rootKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := newTestCertificate(t, &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test root"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil, rootKey, rootKey)
+
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf := newTestCertificate(t, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "dns.example"},
+		DNSNames:     []string{"dns.example"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, root, leafKey, rootKey)
+	return &testChain{root: root, leaf: leaf}
+}
+
+// newTestCertificate signs template with the key of parent, the certificate is self-signed if parent is nil
+func newTestCertificate(t *testing.T, template, parent *x509.Certificate, key, parentKey *ecdsa.PrivateKey) *x509.Certificate {
+	t.Helper()
+	if parent == nil {
+		parent = template
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func newTestTLSA(t *testing.T, usage, selector, matchingType uint8, cert *x509.Certificate) *dns.TLSA {
+	t.Helper()
+	hash, err := dns.CertificateToDANE(selector, matchingType, cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &dns.TLSA{Usage: usage, Selector: selector, MatchingType: matchingType, Certificate: hash}
+}
+
+func TestMatchesTLSARecord(t *testing.T) {
+	chain := newTestChain(t)
+	other := newTestChain(t)
+
+	tests := []struct {
+		name       string
+		rr         *dns.TLSA
+		trusted    bool
+		domainName string
+		want       bool
+	}{
+		{"pkix-ta trusted root", newTestTLSA(t, tlsaPKIXTA, 1, 1, chain.root), true, "dns.example", true},
+		{"pkix-ta untrusted root", newTestTLSA(t, tlsaPKIXTA, 1, 1, chain.root), false, "dns.example", false},
+		{"pkix-ta wrong name", newTestTLSA(t, tlsaPKIXTA, 1, 1, chain.root), true, "other.example", false},
+		{"pkix-ta end entity", newTestTLSA(t, tlsaPKIXTA, 1, 1, chain.leaf), true, "dns.example", false},
+		{"pkix-ta other root", newTestTLSA(t, tlsaPKIXTA, 1, 1, other.root), true, "dns.example", false},
+
+		{"pkix-ee trusted", newTestTLSA(t, tlsaPKIXEE, 1, 1, chain.leaf), true, "dns.example", true},
+		{"pkix-ee full certificate", newTestTLSA(t, tlsaPKIXEE, 0, 0, chain.leaf), true, "dns.example", true},
+		{"pkix-ee untrusted", newTestTLSA(t, tlsaPKIXEE, 1, 1, chain.leaf), false, "dns.example", false},
+		{"pkix-ee wrong name", newTestTLSA(t, tlsaPKIXEE, 1, 1, chain.leaf), true, "other.example", false},
+		{"pkix-ee root", newTestTLSA(t, tlsaPKIXEE, 1, 1, chain.root), true, "dns.example", false},
+
+		{"dane-ta untrusted root", newTestTLSA(t, tlsaDANETA, 1, 1, chain.root), false, "dns.example", true},
+		{"dane-ta sha-512", newTestTLSA(t, tlsaDANETA, 0, 2, chain.root), false, "dns.example", true},
+		{"dane-ta wrong name", newTestTLSA(t, tlsaDANETA, 1, 1, chain.root), false, "other.example", false},
+		{"dane-ta end entity", newTestTLSA(t, tlsaDANETA, 1, 1, chain.leaf), false, "dns.example", false},
+		{"dane-ta other root", newTestTLSA(t, tlsaDANETA, 1, 1, other.root), false, "dns.example", false},
+
+		{"dane-ee untrusted", newTestTLSA(t, tlsaDANEEE, 1, 1, chain.leaf), false, "dns.example", true},
+		{"dane-ee wrong name", newTestTLSA(t, tlsaDANEEE, 1, 1, chain.leaf), false, "other.example", true},
+		{"dane-ee full certificate", newTestTLSA(t, tlsaDANEEE, 0, 0, chain.leaf), false, "dns.example", true},
+		{"dane-ee root", newTestTLSA(t, tlsaDANEEE, 1, 1, chain.root), false, "dns.example", false},
+		{"dane-ee other leaf", newTestTLSA(t, tlsaDANEEE, 1, 1, other.leaf), false, "dns.example", false},
+
+		{"unknown usage", newTestTLSA(t, 4, 1, 1, chain.leaf), true, "dns.example", false},
+	}
+	defer func(roots *x509.CertPool) { RootCAs = roots }(RootCAs)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			RootCAs = x509.NewCertPool()
+			if tt.trusted {
+				RootCAs = chain.roots()
+			}
+			if got := matchesTLSARecord(tt.rr, chain.certs(), tt.domainName); got != tt.want {
+				t.Errorf("matchesTLSARecord() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDANEVerifierVerify(t *testing.T) {
+	chain := newTestChain(t)
+	other := newTestChain(t)
+	match := newTestTLSA(t, tlsaDANEEE, 1, 1, chain.leaf)
+	mismatch := newTestTLSA(t, tlsaDANEEE, 1, 1, other.leaf)
+
+	tests := []struct {
+		name    string
+		records []*dns.TLSA
+		err     error
+		want    *dns.TLSA
+		wantErr error
+	}{
+		{"match", []*dns.TLSA{match}, nil, match, nil},
+		{"first match", []*dns.TLSA{mismatch, match}, nil, match, nil},
+		{"no match", []*dns.TLSA{mismatch}, nil, nil, ErrDANENoMatch},
+		{"lookup failed", nil, ErrDANEInsecure, nil, ErrDANEInsecure},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &daneVerifier{records: tt.records, err: tt.err}
+			got, err := d.verify(chain.certs(), "dns.example")
+			if err != tt.wantErr {
+				t.Fatalf("verify() error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("verify() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,186 @@
+package clients
+
+import (
+	"context"
+	"errors"
+	"github.com/mgranderath/dnsperf/metrics"
+	"net"
+	"strings"
+	"time"
+)
+
+type DialPolicy int
+
+const (
+	// DialSequential tries the resolved addresses one after another in the order they were resolved
+	DialSequential DialPolicy = iota
+
+	// DialHappyEyeballs races connection attempts to the resolved addresses, alternating between IPv6 and IPv4 and
+	// starting the next attempt whenever the previous one failed or has not succeeded within 250ms (RFC 8305)
+	DialHappyEyeballs
+
+	// DialIPv4Only only connects to IPv4 addresses
+	DialIPv4Only
+
+	// DialIPv6Only only connects to IPv6 addresses
+	DialIPv6Only
+
+	// DialPreferIPv6 tries the IPv6 addresses before the IPv4 addresses, one after another
+	DialPreferIPv6
+)
+
+// connectionAttemptDelay is the time to wait for a connection attempt before starting the next one (RFC 8305 section 5)
+const connectionAttemptDelay = 250 * time.Millisecond
+
+func isIPv6Address(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.To4() == nil
+}
+
+// dialAddresses returns the upstream addresses in the order they are dialed according to Options.DialPolicy
+func (c *baseClient) dialAddresses(ctx context.Context, collector *metrics.Collector) ([]string, error) {
+	resolved, err := c.upstreamAddresses(ctx, collector)
+	if err != nil {
+		return nil, err
+	}
+
+	var v4, v6 []string
+	for _, addr := range resolved {
+		if isIPv6Address(addr) {
+			v6 = append(v6, addr)
+		} else {
+			v4 = append(v4, addr)
+		}
+	}
+
+	var addrs []string
+	switch c.options.DialPolicy {
+	case DialHappyEyeballs:
+		// interleave the address families, starting with IPv6 (RFC 8305 section 4)
+		for i := 0; i < len(v4) || i < len(v6); i++ {
+			if i < len(v6) {
+				addrs = append(addrs, v6[i])
+			}
+			if i < len(v4) {
+				addrs = append(addrs, v4[i])
+			}
+		}
+	case DialIPv4Only:
+		addrs = v4
+	case DialIPv6Only:
+		addrs = v6
+	case DialPreferIPv6:
+		addrs = append(v6, v4...)
+	default:
+		addrs = resolved
+	}
+
+	if len(addrs) == 0 {
+		return nil, errors.New("no upstream address matches the dial policy")
+	}
+	return addrs, nil
+}
+
+type attemptResult struct {
+	index int
+	value interface{}
+	err   error
+}
+
+// dialErrors are the errors of the failed connection attempts, errors.Is and errors.As match any of them
+type dialErrors []error
+
+func (e dialErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+func (e dialErrors) Is(target error) bool {
+	for _, err := range e {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e dialErrors) As(target interface{}) bool {
+	for _, err := range e {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// raceAttempts calls attempt for the addresses in order until one succeeds. With race and DialHappyEyeballs the next
+// attempt also starts when the previous one is still pending after connectionAttemptDelay, otherwise only once it
+// failed. It returns the index of the successful attempt, or of the last failed one, together with the number of
+// attempts that failed before. If every attempt failed, the error holds the errors of all of them. Values of attempts
+// that succeed after another one won are passed to discard.
+func (c *baseClient) raceAttempts(ctx context.Context, addrs []string, race bool, attempt func(ctx context.Context, i int) (interface{}, error), discard func(interface{})) (int, interface{}, int, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan attemptResult, len(addrs))
+	started, pending := 0, 0
+	start := func() {
+		i := started
+		started++
+		pending++
+		go func() {
+			value, err := attempt(ctx, i)
+			results <- attemptResult{index: i, value: value, err: err}
+		}()
+	}
+
+	start()
+	failed, last := 0, attemptResult{}
+	var errs dialErrors
+	for pending > 0 {
+		var timer *time.Timer
+		var timeout <-chan time.Time
+		if race && c.options.DialPolicy == DialHappyEyeballs && started < len(addrs) {
+			timer = time.NewTimer(connectionAttemptDelay)
+			timeout = timer.C
+		}
+
+		select {
+		case result := <-results:
+			pending--
+			if timer != nil {
+				timer.Stop()
+			}
+			if result.err == nil {
+				// attempts that are still pending are cancelled by the deferred cancel
+				go func(pending int) {
+					for ; pending > 0; pending-- {
+						if late := <-results; late.err == nil {
+							discard(late.value)
+						}
+					}
+				}(pending)
+				return result.index, result.value, failed, nil
+			}
+			failed++
+			last = result
+			errs = append(errs, result.err)
+			if started < len(addrs) {
+				start()
+			}
+		case <-timeout:
+			start()
+		}
+	}
+	if len(errs) == 1 {
+		return last.index, nil, failed, last.err
+	}
+	return last.index, nil, failed, errs
+}
@@ -0,0 +1,166 @@
+package clients
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDialAddresses(t *testing.T) {
+	resolved := []string{"192.0.2.1:53", "[2001:db8::1]:53", "192.0.2.2:53", "192.0.2.3:53", "[2001:db8::2]:53"}
+
+	tests := []struct {
+		policy DialPolicy
+		want   []string
+	}{
+		{DialSequential, resolved},
+		{DialHappyEyeballs, []string{"[2001:db8::1]:53", "192.0.2.1:53", "[2001:db8::2]:53", "192.0.2.2:53", "192.0.2.3:53"}},
+		{DialIPv4Only, []string{"192.0.2.1:53", "192.0.2.2:53", "192.0.2.3:53"}},
+		{DialIPv6Only, []string{"[2001:db8::1]:53", "[2001:db8::2]:53"}},
+		{DialPreferIPv6, []string{"[2001:db8::1]:53", "[2001:db8::2]:53", "192.0.2.1:53", "192.0.2.2:53", "192.0.2.3:53"}},
+	}
+	for _, tt := range tests {
+		c := &baseClient{resolvedAddresses: resolved, options: Options{DialPolicy: tt.policy}}
+		got, err := c.dialAddresses(context.Background(), nil)
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("dialAddresses() with policy %d = %v, %v, want %v", tt.policy, got, err, tt.want)
+		}
+	}
+
+	c := &baseClient{resolvedAddresses: []string{"192.0.2.1:53"}, options: Options{DialPolicy: DialIPv6Only}}
+	if _, err := c.dialAddresses(context.Background(), nil); err == nil {
+		t.Error("dialAddresses() without a matching address succeeded")
+	}
+}
+
+// stubAttempts records when the attempts started, attempt i runs run(ctx, i)
+type stubAttempts struct {
+	mutex   sync.Mutex
+	started map[int]time.Time
+	run     func(ctx context.Context, i int) (interface{}, error)
+}
+
+func (s *stubAttempts) attempt(ctx context.Context, i int) (interface{}, error) {
+	s.mutex.Lock()
+	if s.started == nil {
+		s.started = make(map[int]time.Time)
+	}
+	s.started[i] = time.Now()
+	s.mutex.Unlock()
+	return s.run(ctx, i)
+}
+
+func (s *stubAttempts) startedAt(i int) (time.Time, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	t, ok := s.started[i]
+	return t, ok
+}
+
+func TestRaceAttemptsFirstSuccessWins(t *testing.T) {
+	c := &baseClient{options: Options{DialPolicy: DialHappyEyeballs}}
+	addrs := []string{"[2001:db8::1]:53", "192.0.2.1:53", "[2001:db8::2]:53"}
+
+	// the first attempt hangs until it is cancelled and succeeds late, the second one succeeds right away
+	stub := &stubAttempts{run: func(ctx context.Context, i int) (interface{}, error) {
+		if i == 0 {
+			<-ctx.Done()
+			return "late", nil
+		}
+		return i, nil
+	}}
+	discarded := make(chan interface{}, len(addrs))
+	index, value, failed, err := c.raceAttempts(context.Background(), addrs, true, stub.attempt, func(value interface{}) {
+		discarded <- value
+	})
+	if index != 1 || value != 1 || failed != 0 || err != nil {
+		t.Fatalf("raceAttempts() = %d, %v, %d, %v, want 1, 1, 0, <nil>", index, value, failed, err)
+	}
+
+	// the connection of the attempt that lost is closed once its cancellation ends it
+	select {
+	case value := <-discarded:
+		if value != "late" {
+			t.Errorf("discarded %v, want late", value)
+		}
+	case <-time.After(time.Second):
+		t.Error("the late attempt was not cancelled")
+	}
+	if _, ok := stub.startedAt(2); ok {
+		t.Error("an attempt started after another one won")
+	}
+}
+
+func TestRaceAttemptsStaggeredStart(t *testing.T) {
+	addrs := []string{"[2001:db8::1]:53", "192.0.2.1:53"}
+	tests := []struct {
+		name      string
+		policy    DialPolicy
+		race      bool
+		failAfter time.Duration
+		wantDelay time.Duration
+	}{
+		// a pending attempt delays the next one by connectionAttemptDelay
+		{"happy eyeballs", DialHappyEyeballs, true, 2 * connectionAttemptDelay, connectionAttemptDelay},
+		// a failed attempt starts the next one right away
+		{"happy eyeballs failure", DialHappyEyeballs, true, connectionAttemptDelay / 5, connectionAttemptDelay / 5},
+		{"sequential", DialSequential, true, connectionAttemptDelay + 100*time.Millisecond, connectionAttemptDelay + 100*time.Millisecond},
+		{"no race", DialHappyEyeballs, false, connectionAttemptDelay + 100*time.Millisecond, connectionAttemptDelay + 100*time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &baseClient{options: Options{DialPolicy: tt.policy}}
+			stub := &stubAttempts{run: func(ctx context.Context, i int) (interface{}, error) {
+				if i == 0 {
+					select {
+					case <-time.After(tt.failAfter):
+						return nil, errors.New("unreachable")
+					case <-ctx.Done():
+						return nil, ctx.Err()
+					}
+				}
+				return i, nil
+			}}
+			index, _, _, err := c.raceAttempts(context.Background(), addrs, tt.race, stub.attempt, func(interface{}) {})
+			if index != 1 || err != nil {
+				t.Fatalf("raceAttempts() = %d, %v, want 1, <nil>", index, err)
+			}
+
+			first, _ := stub.startedAt(0)
+			second, _ := stub.startedAt(1)
+			if delay := second.Sub(first); delay < tt.wantDelay || delay > tt.wantDelay+connectionAttemptDelay/2 {
+				t.Errorf("second attempt started after %v, want %v", delay, tt.wantDelay)
+			}
+		})
+	}
+}
+
+func TestRaceAttemptsAllFail(t *testing.T) {
+	c := &baseClient{options: Options{DialPolicy: DialHappyEyeballs}}
+	attemptErrors := []error{errors.New("refused"), errors.New("unreachable"), errors.New("timed out")}
+	stub := &stubAttempts{run: func(ctx context.Context, i int) (interface{}, error) {
+		return nil, attemptErrors[i]
+	}}
+
+	index, value, failed, err := c.raceAttempts(context.Background(), []string{"a", "b", "c"}, true, stub.attempt, func(interface{}) {})
+	if index != 2 || value != nil || failed != 3 {
+		t.Errorf("raceAttempts() = %d, %v, %d, want 2, <nil>, 3", index, value, failed)
+	}
+	for _, attemptErr := range attemptErrors {
+		if !errors.Is(err, attemptErr) {
+			t.Errorf("raceAttempts() error %v does not hold %v", err, attemptErr)
+		}
+	}
+	if want := "refused; unreachable; timed out"; err == nil || err.Error() != want {
+		t.Errorf("raceAttempts() error = %v, want %s", err, want)
+	}
+
+	// the error of a single attempt is returned as it is
+	_, _, _, err = c.raceAttempts(context.Background(), []string{"a"}, true, stub.attempt, func(interface{}) {})
+	if err != attemptErrors[0] {
+		t.Errorf("raceAttempts() error = %v, want %v", err, attemptErrors[0])
+	}
+}
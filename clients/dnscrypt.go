@@ -0,0 +1,319 @@
+package clients
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"github.com/mgranderath/dnsperf/metrics"
+	"github.com/miekg/dns"
+	"golang.org/x/crypto/chacha20"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/nacl/box"
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/poly1305"
+	"sync"
+	"time"
+)
+
+const (
+	dnscryptClientNonceSize = 12
+	dnscryptNonceSize       = 24
+	dnscryptTagSize         = poly1305.TagSize
+
+	// dnscryptMinUDPQuerySize is the minimum size of the padded query before encryption over UDP, it keeps
+	// responses from being much larger than queries, larger responses are truncated by the server
+	dnscryptMinUDPQuerySize = 256
+	dnscryptPaddingBlock    = 64
+)
+
+var dnscryptResolverMagic = []byte{0x72, 0x36, 0x66, 0x6e, 0x76, 0x57, 0x6a, 0x38}
+
+// DNSCryptClient sends queries to a DNSCrypt v2 server (https://dnscrypt.info/protocol) described by a sdns:// stamp
+type DNSCryptClient struct {
+	baseClient *baseClient
+	stamp      *DNSCryptStamp
+
+	// session is kept between exchanges when Options.ReuseConnection is set
+	mutex   sync.Mutex
+	session *dnscryptSession
+}
+
+// dnscryptSession is a resolver certificate together with the client key pair used with it
+type dnscryptSession struct {
+	cert      *dnscryptCert
+	publicKey [32]byte
+	sharedKey [32]byte
+}
+
+func (c *DNSCryptClient) Exchange(m *dns.Msg) *metrics.WithResponseOrError {
+	return c.ExchangeContext(context.Background(), m)
+}
+
+func (c *DNSCryptClient) ExchangeContext(ctx context.Context, m *dns.Msg) *metrics.WithResponseOrError {
+	if c.baseClient.options.ReuseConnection {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+	}
+
+	collector := metrics.NewCollector()
+	result := c.exchange(ctx, m, collector)
+	handleContextError(ctx, result.GetError(), collector)
+	return result
+}
+
+func (c *DNSCryptClient) exchange(ctx context.Context, m *dns.Msg, collector *metrics.Collector) *metrics.WithResponseOrError {
+	collector.ExchangeStarted()
+
+	network := "udp"
+	if c.baseClient.options.DNSCryptOptions != nil && c.baseClient.options.DNSCryptOptions.UseTCP {
+		network = "tcp"
+	}
+
+	session, err := c.getSession(ctx, network, collector)
+	if err != nil {
+		return collector.WithError(err)
+	}
+	reply, err := c.exchangeEncrypted(ctx, network, m, session, collector)
+	if err == nil && reply.Truncated && network == "udp" && c.baseClient.options.Truncation == TruncationRetryTCP {
+		collector.TCPFallback()
+		reply, err = c.exchangeEncrypted(ctx, "tcp", m, session, collector)
+	}
+	if err != nil {
+		return collector.WithError(err)
+	}
+	if reply.Id != m.Id {
+		err = dns.ErrId
+	}
+
+	collector.ExchangeFinished()
+	return collector.WithResponseAndError(reply, err)
+}
+
+// getSession returns the session kept between exchanges when Options.ReuseConnection is set and its certificate is
+// still valid, otherwise it fetches the certificates and creates a new session
+func (c *DNSCryptClient) getSession(ctx context.Context, network string, collector *metrics.Collector) (*dnscryptSession, error) {
+	if c.session != nil && time.Now().Before(c.session.cert.notAfter) {
+		collector.DNSCryptVersion(c.session.cert.esVersion)
+		return c.session, nil
+	}
+
+	cert, err := c.fetchCert(ctx, network, collector)
+	if err != nil {
+		return nil, err
+	}
+	session, err := newDNSCryptSession(cert)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.baseClient.options.ReuseConnection {
+		c.session = session
+	}
+	return session, nil
+}
+
+func newDNSCryptSession(cert *dnscryptCert) (*dnscryptSession, error) {
+	publicKey, secretKey, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	session := &dnscryptSession{cert: cert, publicKey: *publicKey}
+	switch cert.esVersion {
+	case DNSCryptXSalsa20Poly1305:
+		box.Precompute(&session.sharedKey, &cert.resolverPK, secretKey)
+	case DNSCryptXChacha20Poly1305:
+		dhKey, err := curve25519.X25519(secretKey[:], cert.resolverPK[:])
+		if err != nil {
+			return nil, err
+		}
+		sharedKey, err := chacha20.HChaCha20(dhKey, make([]byte, 16))
+		if err != nil {
+			return nil, err
+		}
+		copy(session.sharedKey[:], sharedKey)
+	}
+	return session, nil
+}
+
+// exchangeEncrypted sends the encrypted query over network and decrypts the response
+func (c *DNSCryptClient) exchangeEncrypted(ctx context.Context, network string, m *dns.Msg, session *dnscryptSession, collector *metrics.Collector) (*dns.Msg, error) {
+	packed, err := m.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	encryptionStart := time.Now()
+	query, clientNonce, err := session.encrypt(packed, network == "udp")
+	collector.DNSCryptEncryptionOverhead(time.Since(encryptionStart))
+	if err != nil {
+		return nil, err
+	}
+
+	rawCon, err := c.baseClient.getDialContext(collector)(ctx, network, "")
+	if err != nil {
+		return nil, err
+	}
+	defer rawCon.Close()
+
+	cn := dns.Conn{Conn: rawCon}
+	_ = cn.SetDeadline(c.baseClient.getDeadline(ctx))
+	defer watchContext(ctx, rawCon)()
+
+	collector.QuerySend()
+	if _, err := cn.Write(query); err != nil {
+		return nil, err
+	}
+	collector.QuerySize(len(query))
+
+	buf := make([]byte, dns.MaxMsgSize)
+	n, err := cn.Read(buf)
+	collector.QueryReceive()
+	if err != nil {
+		return nil, err
+	}
+	collector.ResponseSize(n)
+
+	decryptionStart := time.Now()
+	packed, err = session.decrypt(buf[:n], clientNonce)
+	collector.DNSCryptEncryptionOverhead(time.Since(decryptionStart))
+	if err != nil {
+		return nil, err
+	}
+
+	reply := new(dns.Msg)
+	if err := reply.Unpack(packed); err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
+// encrypt pads and encrypts a packed query, it returns the query as sent on the wire and the client nonce
+func (s *dnscryptSession) encrypt(packed []byte, udp bool) ([]byte, []byte, error) {
+	minSize := 0
+	if udp {
+		minSize = dnscryptMinUDPQuerySize
+	}
+	padded := dnscryptPad(packed, minSize)
+
+	var nonce [dnscryptNonceSize]byte
+	if _, err := rand.Read(nonce[:dnscryptClientNonceSize]); err != nil {
+		return nil, nil, err
+	}
+
+	query := make([]byte, 0, len(s.cert.clientMagic)+len(s.publicKey)+dnscryptClientNonceSize+dnscryptTagSize+len(padded))
+	query = append(query, s.cert.clientMagic[:]...)
+	query = append(query, s.publicKey[:]...)
+	query = append(query, nonce[:dnscryptClientNonceSize]...)
+	switch s.cert.esVersion {
+	case DNSCryptXSalsa20Poly1305:
+		query = secretbox.Seal(query, padded, &nonce, &s.sharedKey)
+	default:
+		query = xchachaSeal(query, padded, &nonce, &s.sharedKey)
+	}
+	return query, nonce[:dnscryptClientNonceSize], nil
+}
+
+// decrypt checks and decrypts a response and removes its padding
+func (s *dnscryptSession) decrypt(response []byte, clientNonce []byte) ([]byte, error) {
+	headerSize := len(dnscryptResolverMagic) + dnscryptNonceSize
+	if len(response) < headerSize+dnscryptTagSize {
+		return nil, errors.New("dnscrypt response is too short")
+	}
+	if !bytes.Equal(response[:len(dnscryptResolverMagic)], dnscryptResolverMagic) {
+		return nil, errors.New("dnscrypt response has an invalid magic")
+	}
+
+	var nonce [dnscryptNonceSize]byte
+	copy(nonce[:], response[len(dnscryptResolverMagic):headerSize])
+	if subtle.ConstantTimeCompare(nonce[:dnscryptClientNonceSize], clientNonce) != 1 {
+		return nil, errors.New("dnscrypt response has an unexpected nonce")
+	}
+
+	var padded []byte
+	var ok bool
+	switch s.cert.esVersion {
+	case DNSCryptXSalsa20Poly1305:
+		padded, ok = secretbox.Open(nil, response[headerSize:], &nonce, &s.sharedKey)
+	default:
+		padded, ok = xchachaOpen(nil, response[headerSize:], &nonce, &s.sharedKey)
+	}
+	if !ok {
+		return nil, errors.New("couldn't decrypt dnscrypt response")
+	}
+	return dnscryptUnpad(padded)
+}
+
+// dnscryptPad appends the 0x80 marker and zeros up to a multiple of 64 bytes that is at least minSize
+func dnscryptPad(packet []byte, minSize int) []byte {
+	size := len(packet) + 1
+	if size < minSize {
+		size = minSize
+	}
+	size = (size + dnscryptPaddingBlock - 1) / dnscryptPaddingBlock * dnscryptPaddingBlock
+
+	padded := make([]byte, size)
+	copy(padded, packet)
+	padded[len(packet)] = 0x80
+	return padded
+}
+
+// dnscryptUnpad removes the zeros and the 0x80 marker at the end of a decrypted packet, the padding is scanned byte
+// by byte as the packet is not text
+func dnscryptUnpad(padded []byte) ([]byte, error) {
+	i := len(padded) - 1
+	for i >= 0 && padded[i] == 0 {
+		i--
+	}
+	if i < 0 || padded[i] != 0x80 {
+		return nil, errors.New("dnscrypt response has invalid padding")
+	}
+	return padded[:i], nil
+}
+
+// xchachaSeal encrypts like secretbox.Seal but with XChaCha20 instead of XSalsa20, the construction used by DNSCrypt:
+// the first 32 bytes of the key stream are the Poly1305 key and the tag precedes the ciphertext
+func xchachaSeal(out, message []byte, nonce *[dnscryptNonceSize]byte, key *[32]byte) []byte {
+	cipher, polyKey := xchachaCipher(nonce, key)
+
+	ret := append(out, make([]byte, dnscryptTagSize+len(message))...)
+	sealed := ret[len(out):]
+	cipher.XORKeyStream(sealed[dnscryptTagSize:], message)
+
+	var tag [dnscryptTagSize]byte
+	poly1305.Sum(&tag, sealed[dnscryptTagSize:], polyKey)
+	copy(sealed, tag[:])
+	return ret
+}
+
+// xchachaOpen reverses xchachaSeal
+func xchachaOpen(out, box []byte, nonce *[dnscryptNonceSize]byte, key *[32]byte) ([]byte, bool) {
+	if len(box) < dnscryptTagSize {
+		return nil, false
+	}
+	cipher, polyKey := xchachaCipher(nonce, key)
+
+	var tag [dnscryptTagSize]byte
+	copy(tag[:], box)
+	if !poly1305.Verify(&tag, box[dnscryptTagSize:], polyKey) {
+		return nil, false
+	}
+
+	ret := append(out, make([]byte, len(box)-dnscryptTagSize)...)
+	cipher.XORKeyStream(ret[len(out):], box[dnscryptTagSize:])
+	return ret, true
+}
+
+// xchachaCipher returns the XChaCha20 key stream positioned after the Poly1305 key taken from its first 32 bytes
+func xchachaCipher(nonce *[dnscryptNonceSize]byte, key *[32]byte) (*chacha20.Cipher, *[32]byte) {
+	cipher, err := chacha20.NewUnauthenticatedCipher(key[:], nonce[:])
+	if err != nil {
+		// only happens with invalid key or nonce sizes, which the array types rule out
+		panic(err)
+	}
+	var polyKey [32]byte
+	cipher.XORKeyStream(polyKey[:], polyKey[:])
+	return cipher, &polyKey
+}
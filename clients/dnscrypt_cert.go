@@ -0,0 +1,166 @@
+package clients
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/mgranderath/dnsperf/metrics"
+	"github.com/miekg/dns"
+	"strings"
+	"time"
+)
+
+// DNSCrypt encryption systems (es-version of a certificate)
+const (
+	DNSCryptXSalsa20Poly1305  uint16 = 0x0001
+	DNSCryptXChacha20Poly1305 uint16 = 0x0002
+)
+
+var dnscryptCertMagic = []byte("DNSC")
+
+// dnscryptCertSize is the size of a certificate without extensions
+const dnscryptCertSize = 124
+
+type dnscryptCert struct {
+	esVersion   uint16
+	resolverPK  [32]byte
+	clientMagic [8]byte
+	serial      uint32
+	notBefore   time.Time
+	notAfter    time.Time
+}
+
+// parseDNSCryptCert decodes a certificate and verifies its signature with the provider key
+func parseDNSCryptCert(bin []byte, providerPK ed25519.PublicKey) (*dnscryptCert, error) {
+	if len(bin) < dnscryptCertSize {
+		return nil, fmt.Errorf("certificate has %d bytes, expected at least %d", len(bin), dnscryptCertSize)
+	}
+	if !bytes.Equal(bin[:4], dnscryptCertMagic) {
+		return nil, errors.New("certificate has an invalid magic")
+	}
+	if !ed25519.Verify(providerPK, bin[72:], bin[8:72]) {
+		return nil, errors.New("certificate has an invalid signature")
+	}
+
+	cert := &dnscryptCert{
+		esVersion: binary.BigEndian.Uint16(bin[4:6]),
+		serial:    binary.BigEndian.Uint32(bin[112:116]),
+		notBefore: time.Unix(int64(binary.BigEndian.Uint32(bin[116:120])), 0),
+		notAfter:  time.Unix(int64(binary.BigEndian.Uint32(bin[120:124])), 0),
+	}
+	copy(cert.resolverPK[:], bin[72:104])
+	copy(cert.clientMagic[:], bin[104:112])
+	return cert, nil
+}
+
+// better reports whether c should be used instead of other, newer serials win and XChaCha20 is preferred
+func (c *dnscryptCert) better(other *dnscryptCert) bool {
+	if other == nil {
+		return true
+	}
+	if c.serial != other.serial {
+		return c.serial > other.serial
+	}
+	return c.esVersion > other.esVersion
+}
+
+// fetchCert queries the certificates of the provider and returns the best one that is currently valid
+func (c *DNSCryptClient) fetchCert(ctx context.Context, network string, collector *metrics.Collector) (*dnscryptCert, error) {
+	collector.DNSCryptCertFetchStart()
+
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(c.stamp.ProviderName), dns.TypeTXT)
+	m.SetEdns0(dns.DefaultMsgSize, false)
+
+	reply, err := c.certExchange(ctx, network, m)
+	if err == nil && reply.Truncated && network == "udp" {
+		reply, err = c.certExchange(ctx, "tcp", m)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("couldn't fetch certificates of %s: %w", c.stamp.ProviderName, err)
+	}
+	if reply.Rcode != dns.RcodeSuccess {
+		return nil, fmt.Errorf("certificate query for %s failed with %s", c.stamp.ProviderName, dns.RcodeToString[reply.Rcode])
+	}
+
+	var best *dnscryptCert
+	var certErr error
+	now := time.Now()
+	for _, rr := range reply.Answer {
+		txt, ok := rr.(*dns.TXT)
+		if !ok {
+			continue
+		}
+		cert, err := parseDNSCryptCert(unescapeTXT(strings.Join(txt.Txt, "")), c.stamp.ProviderPK)
+		switch {
+		case err != nil:
+			certErr = err
+		case cert.esVersion != DNSCryptXSalsa20Poly1305 && cert.esVersion != DNSCryptXChacha20Poly1305:
+			certErr = fmt.Errorf("certificate uses unsupported encryption system 0x%04x", cert.esVersion)
+		case now.Before(cert.notBefore) || now.After(cert.notAfter):
+			certErr = fmt.Errorf("certificate %d is valid from %s to %s", cert.serial, cert.notBefore, cert.notAfter)
+		case cert.better(best):
+			best = cert
+		}
+	}
+	if best == nil {
+		if certErr == nil {
+			certErr = errors.New("no certificate in response")
+		}
+		return nil, certErr
+	}
+
+	collector.DNSCryptCertFetchDone(best.esVersion)
+	return best, nil
+}
+
+// certExchange sends the plain text certificate query to the server
+func (c *DNSCryptClient) certExchange(ctx context.Context, network string, m *dns.Msg) (*dns.Msg, error) {
+	rawCon, err := c.baseClient.getDialContext(nil)(ctx, network, "")
+	if err != nil {
+		return nil, err
+	}
+	defer rawCon.Close()
+
+	cn := dns.Conn{Conn: rawCon, UDPSize: dns.DefaultMsgSize}
+	_ = cn.SetDeadline(c.baseClient.getDeadline(ctx))
+	defer watchContext(ctx, rawCon)()
+
+	if err := cn.WriteMsg(m); err != nil {
+		return nil, err
+	}
+	reply, err := cn.ReadMsg()
+	if err != nil {
+		return nil, err
+	}
+	if reply.Id != m.Id {
+		return nil, dns.ErrId
+	}
+	return reply, nil
+}
+
+// unescapeTXT reverses the presentation format escaping of TXT strings, certificates are binary data
+func unescapeTXT(s string) []byte {
+	b := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b = append(b, s[i])
+			continue
+		}
+		i++
+		if i+2 < len(s) && isDigit(s[i]) && isDigit(s[i+1]) && isDigit(s[i+2]) {
+			b = append(b, (s[i]-'0')*100+(s[i+1]-'0')*10+(s[i+2]-'0'))
+			i += 2
+			continue
+		}
+		b = append(b, s[i])
+	}
+	return b
+}
+
+func isDigit(b byte) bool {
+	return b >= '0' && b <= '9'
+}
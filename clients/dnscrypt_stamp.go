@@ -0,0 +1,90 @@
+package clients
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// stampProtoDNSCrypt is the protocol identifier of DNSCrypt server stamps
+const stampProtoDNSCrypt = 0x01
+
+// dnscryptDefaultPort is used when the stamp address has no port
+const dnscryptDefaultPort = "443"
+
+// DNSCryptStamp is a decoded DNSCrypt server stamp, see https://dnscrypt.info/stamps-specifications
+type DNSCryptStamp struct {
+	// Props are the informal properties of the server (DNSSEC, no logs, no filter)
+	Props uint64
+
+	// ServerAddr is the IP address and port of the server
+	ServerAddr string
+
+	// ProviderPK is the long-term key signing the resolver certificates
+	ProviderPK ed25519.PublicKey
+
+	// ProviderName is the name the certificates are queried for, e.g. 2.dnscrypt-cert.example.com
+	ProviderName string
+}
+
+// ParseDNSCryptStamp decodes a sdns:// stamp of a DNSCrypt server
+func ParseDNSCryptStamp(stamp string) (*DNSCryptStamp, error) {
+	if !strings.HasPrefix(stamp, "sdns://") {
+		return nil, errors.New("stamps must start with sdns://")
+	}
+	bin, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(stamp, "sdns://"))
+	if err != nil {
+		return nil, fmt.Errorf("couldn't decode stamp: %w", err)
+	}
+	if len(bin) < 1+8 {
+		return nil, errors.New("stamp is too short")
+	}
+	if bin[0] != stampProtoDNSCrypt {
+		return nil, fmt.Errorf("stamp protocol 0x%02x is not DNSCrypt", bin[0])
+	}
+
+	s := &DNSCryptStamp{Props: binary.LittleEndian.Uint64(bin[1:9])}
+	rest := bin[9:]
+
+	var addr, pk, name []byte
+	for _, field := range []*[]byte{&addr, &pk, &name} {
+		if len(rest) < 1 || len(rest) < 1+int(rest[0]) {
+			return nil, errors.New("stamp is truncated")
+		}
+		*field, rest = rest[1:1+int(rest[0])], rest[1+int(rest[0]):]
+	}
+	if len(rest) != 0 {
+		return nil, errors.New("stamp has trailing data")
+	}
+
+	s.ServerAddr, err = stampAddress(string(addr))
+	if err != nil {
+		return nil, err
+	}
+	if len(pk) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("provider public key has %d bytes instead of %d", len(pk), ed25519.PublicKeySize)
+	}
+	s.ProviderPK = pk
+	if len(name) == 0 {
+		return nil, errors.New("stamp has no provider name")
+	}
+	s.ProviderName = string(name)
+
+	return s, nil
+}
+
+// stampAddress adds the default port to addresses like 1.2.3.4 or [::1]
+func stampAddress(addr string) (string, error) {
+	if _, _, err := net.SplitHostPort(addr); err == nil {
+		return addr, nil
+	}
+	host := strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+	if net.ParseIP(host) == nil {
+		return "", fmt.Errorf("stamp address %q is not an IP address", addr)
+	}
+	return net.JoinHostPort(host, dnscryptDefaultPort), nil
+}
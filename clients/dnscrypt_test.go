@@ -0,0 +1,291 @@
+package clients
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"golang.org/x/crypto/chacha20"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/nacl/box"
+	"golang.org/x/crypto/nacl/secretbox"
+	"testing"
+	"time"
+)
+
+func TestDNSCryptUnpad(t *testing.T) {
+	tests := []struct {
+		name    string
+		padded  []byte
+		want    []byte
+		wantErr bool
+	}{
+		{"marker only", []byte{0x80}, []byte{}, false},
+		{"marker and zeros", []byte{1, 2, 3, 0x80, 0, 0, 0}, []byte{1, 2, 3}, false},
+		// 0xC8 0x80 is a valid UTF-8 rune, the padding must not be decoded as text
+		{"trailing 0xc8", []byte{1, 2, 3, 0xc8, 0x80, 0, 0, 0}, []byte{1, 2, 3, 0xc8}, false},
+		{"trailing 0xc2", []byte{0xc2, 0x80, 0}, []byte{0xc2}, false},
+		{"trailing 0xdf", []byte{0xdf, 0x80}, []byte{0xdf}, false},
+		{"marker in packet", []byte{0x80, 0x80, 0}, []byte{0x80}, false},
+		{"no marker", []byte{1, 2, 3, 0, 0}, nil, true},
+		{"wrong marker", []byte{1, 2, 0x81, 0, 0}, nil, true},
+		{"zeros only", []byte{0, 0, 0}, nil, true},
+		{"empty", []byte{}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := dnscryptUnpad(tt.padded)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("dnscryptUnpad() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !bytes.Equal(got, tt.want) {
+				t.Errorf("dnscryptUnpad() = %x, want %x", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDNSCryptPad(t *testing.T) {
+	tests := []struct {
+		name    string
+		size    int
+		minSize int
+		want    int
+	}{
+		{"small udp query", 40, dnscryptMinUDPQuerySize, 256},
+		{"udp query at minimum", 255, dnscryptMinUDPQuerySize, 256},
+		{"large udp query", 256, dnscryptMinUDPQuerySize, 320},
+		{"small tcp query", 40, 0, 64},
+		{"tcp query at block", 63, 0, 64},
+		{"tcp query over block", 64, 0, 128},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			packet := bytes.Repeat([]byte{0xc8}, tt.size)
+			padded := dnscryptPad(packet, tt.minSize)
+			if len(padded) != tt.want {
+				t.Fatalf("len(dnscryptPad()) = %d, want %d", len(padded), tt.want)
+			}
+			unpadded, err := dnscryptUnpad(padded)
+			if err != nil {
+				t.Fatalf("dnscryptUnpad() error = %v", err)
+			}
+			if !bytes.Equal(unpadded, packet) {
+				t.Errorf("dnscryptUnpad(dnscryptPad()) = %x, want %x", unpadded, packet)
+			}
+		})
+	}
+}
+
+// newTestDNSCryptCert returns a certificate for resolverPK signed with providerSK
+func newTestDNSCryptCert(providerSK ed25519.PrivateKey, esVersion uint16, resolverPK *[32]byte, serial uint32) []byte {
+	bin := make([]byte, dnscryptCertSize)
+	copy(bin, dnscryptCertMagic)
+	binary.BigEndian.PutUint16(bin[4:6], esVersion)
+	copy(bin[72:104], resolverPK[:])
+	copy(bin[104:112], "clientmg")
+	binary.BigEndian.PutUint32(bin[112:116], serial)
+	binary.BigEndian.PutUint32(bin[116:120], 1000)
+	binary.BigEndian.PutUint32(bin[120:124], 2000)
+	copy(bin[8:72], ed25519.Sign(providerSK, bin[72:]))
+	return bin
+}
+
+func TestParseDNSCryptCert(t *testing.T) {
+	providerPK, providerSK, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPK, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolverPK := [32]byte{1, 2, 3}
+	valid := newTestDNSCryptCert(providerSK, DNSCryptXChacha20Poly1305, &resolverPK, 42)
+
+	tests := []struct {
+		name       string
+		bin        []byte
+		providerPK ed25519.PublicKey
+		wantErr    bool
+	}{
+		{"valid", valid, providerPK, false},
+		{"unsigned extensions", append(append([]byte{}, valid...), 1, 2, 3), providerPK, true},
+		{"too short", valid[:dnscryptCertSize-1], providerPK, true},
+		{"invalid magic", append([]byte("DNSX"), valid[4:]...), providerPK, true},
+		{"other provider", valid, otherPK, true},
+		{"modified serial", append(append([]byte{}, valid[:112]...), 0, 0, 0, 43, 0, 0, 3, 232, 0, 0, 7, 208), providerPK, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cert, err := parseDNSCryptCert(tt.bin, tt.providerPK)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseDNSCryptCert() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			want := &dnscryptCert{
+				esVersion:   DNSCryptXChacha20Poly1305,
+				resolverPK:  resolverPK,
+				clientMagic: [8]byte{'c', 'l', 'i', 'e', 'n', 't', 'm', 'g'},
+				serial:      42,
+				notBefore:   time.Unix(1000, 0),
+				notAfter:    time.Unix(2000, 0),
+			}
+			if *cert != *want {
+				t.Errorf("parseDNSCryptCert() = %+v, want %+v", cert, want)
+			}
+		})
+	}
+}
+
+func TestDNSCryptCertBetter(t *testing.T) {
+	tests := []struct {
+		name  string
+		cert  *dnscryptCert
+		other *dnscryptCert
+		want  bool
+	}{
+		{"no other", &dnscryptCert{serial: 1}, nil, true},
+		{"newer serial", &dnscryptCert{serial: 2, esVersion: DNSCryptXSalsa20Poly1305}, &dnscryptCert{serial: 1, esVersion: DNSCryptXChacha20Poly1305}, true},
+		{"older serial", &dnscryptCert{serial: 1, esVersion: DNSCryptXChacha20Poly1305}, &dnscryptCert{serial: 2, esVersion: DNSCryptXSalsa20Poly1305}, false},
+		{"xchacha preferred", &dnscryptCert{serial: 1, esVersion: DNSCryptXChacha20Poly1305}, &dnscryptCert{serial: 1, esVersion: DNSCryptXSalsa20Poly1305}, true},
+		{"xsalsa not preferred", &dnscryptCert{serial: 1, esVersion: DNSCryptXSalsa20Poly1305}, &dnscryptCert{serial: 1, esVersion: DNSCryptXChacha20Poly1305}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.cert.better(tt.other); got != tt.want {
+				t.Errorf("better() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnescapeTXT(t *testing.T) {
+	tests := []struct {
+		s    string
+		want []byte
+	}{
+		{"DNSC", []byte("DNSC")},
+		{`\000\001\255`, []byte{0, 1, 255}},
+		{`a\"b\\`, []byte(`a"b\`)},
+		{`\12`, []byte("12")},
+		{`trailing\`, []byte(`trailing\`)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			if got := unescapeTXT(tt.s); !bytes.Equal(got, tt.want) {
+				t.Errorf("unescapeTXT() = %x, want %x", got, tt.want)
+			}
+		})
+	}
+}
+
+// dnscryptResolverKey derives the key the resolver shares with the client of query
+func dnscryptResolverKey(t *testing.T, esVersion uint16, query []byte, resolverSK *[32]byte) *[32]byte {
+	var clientPK [32]byte
+	copy(clientPK[:], query[8:40])
+
+	var sharedKey [32]byte
+	switch esVersion {
+	case DNSCryptXSalsa20Poly1305:
+		box.Precompute(&sharedKey, &clientPK, resolverSK)
+	case DNSCryptXChacha20Poly1305:
+		dhKey, err := curve25519.X25519(resolverSK[:], clientPK[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		key, err := chacha20.HChaCha20(dhKey, make([]byte, 16))
+		if err != nil {
+			t.Fatal(err)
+		}
+		copy(sharedKey[:], key)
+	}
+	return &sharedKey
+}
+
+func TestDNSCryptSessionRoundTrip(t *testing.T) {
+	tests := []struct {
+		name      string
+		esVersion uint16
+		udp       bool
+	}{
+		{"xsalsa20 udp", DNSCryptXSalsa20Poly1305, true},
+		{"xsalsa20 tcp", DNSCryptXSalsa20Poly1305, false},
+		{"xchacha20 udp", DNSCryptXChacha20Poly1305, true},
+		{"xchacha20 tcp", DNSCryptXChacha20Poly1305, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolverPK, resolverSK, err := box.GenerateKey(rand.Reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cert := &dnscryptCert{esVersion: tt.esVersion, resolverPK: *resolverPK, clientMagic: [8]byte{'c', 'l', 'i', 'e', 'n', 't', 'm', 'g'}}
+			session, err := newDNSCryptSession(cert)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			packet := []byte("query")
+			query, clientNonce, err := session.encrypt(packet, tt.udp)
+			if err != nil {
+				t.Fatalf("encrypt() error = %v", err)
+			}
+			if !bytes.Equal(query[:8], cert.clientMagic[:]) {
+				t.Fatalf("encrypt() magic = %x, want %x", query[:8], cert.clientMagic)
+			}
+			if tt.udp && len(query) < 52+dnscryptMinUDPQuerySize {
+				t.Errorf("len(encrypt()) = %d, want at least %d", len(query), 52+dnscryptMinUDPQuerySize)
+			}
+
+			// decrypt the query like the resolver does
+			sharedKey := dnscryptResolverKey(t, tt.esVersion, query, resolverSK)
+			var nonce [dnscryptNonceSize]byte
+			copy(nonce[:], query[40:52])
+			var padded []byte
+			var ok bool
+			if tt.esVersion == DNSCryptXSalsa20Poly1305 {
+				padded, ok = secretbox.Open(nil, query[52:], &nonce, sharedKey)
+			} else {
+				padded, ok = xchachaOpen(nil, query[52:], &nonce, sharedKey)
+			}
+			if !ok {
+				t.Fatal("resolver couldn't decrypt query")
+			}
+			if got, err := dnscryptUnpad(padded); err != nil || !bytes.Equal(got, packet) {
+				t.Fatalf("resolver decrypted %q, %v, want %q", got, err, packet)
+			}
+
+			// answer with the client nonce and a resolver nonce
+			copy(nonce[dnscryptClientNonceSize:], "resolvernonc")
+			response := append(append([]byte{}, dnscryptResolverMagic...), nonce[:]...)
+			if tt.esVersion == DNSCryptXSalsa20Poly1305 {
+				response = secretbox.Seal(response, dnscryptPad([]byte("response"), 0), &nonce, sharedKey)
+			} else {
+				response = xchachaSeal(response, dnscryptPad([]byte("response"), 0), &nonce, sharedKey)
+			}
+
+			got, err := session.decrypt(response, clientNonce)
+			if err != nil {
+				t.Fatalf("decrypt() error = %v", err)
+			}
+			if string(got) != "response" {
+				t.Errorf("decrypt() = %q, want %q", got, "response")
+			}
+
+			tampered := append([]byte{}, response...)
+			tampered[len(tampered)-1] ^= 1
+			if _, err := session.decrypt(tampered, clientNonce); err == nil {
+				t.Error("decrypt() of tampered response succeeded")
+			}
+			if _, err := session.decrypt(response, make([]byte, dnscryptClientNonceSize)); err == nil {
+				t.Error("decrypt() with other client nonce succeeded")
+			}
+			if _, err := session.decrypt(response[:len(dnscryptResolverMagic)+dnscryptNonceSize], clientNonce); err == nil {
+				t.Error("decrypt() of short response succeeded")
+			}
+		})
+	}
+}
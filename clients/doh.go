@@ -2,7 +2,9 @@ package clients
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/base64"
+	"fmt"
 	"github.com/joomcode/errorx"
 	"github.com/mgranderath/dnsperf/metrics"
 	"github.com/miekg/dns"
@@ -10,17 +12,19 @@ import (
 	"io/ioutil"
 	"net"
 	"net/http"
+	"net/http/httptrace"
+	"sync"
 )
 
-// WrappedTransport wraps the default http.Transport so that we can set the query finish time
+// WrappedTransport wraps the underlying http.RoundTripper so that we can set the query finish time
 type WrappedTransport struct {
-	collector *metrics.Collector
-	transport *http.Transport
+	collector *collectorHolder
+	transport http.RoundTripper
 }
 
 func (w *WrappedTransport) RoundTrip(r *http.Request) (*http.Response, error) {
 	response, err := w.transport.RoundTrip(r)
-	w.collector.QueryReceive()
+	w.collector.get().QueryReceive()
 	return response, err
 }
 
@@ -29,22 +33,41 @@ const DoHMaxConnsPerHost = 1
 
 type DoHClient struct {
 	baseClient *baseClient
+
+	// client and collector are kept between exchanges when Options.ReuseConnection is set
+	mutex     sync.Mutex
+	client    *http.Client
+	collector *collectorHolder
 }
 
-func (c *DoHClient) exchangeHTTPSClient(m *dns.Msg, client *http.Client, collector *metrics.Collector) (*dns.Msg, error) {
+func (c *baseClient) exchangeHTTPSClient(ctx context.Context, upstreamURL string, m *dns.Msg, client *http.Client, collector *metrics.Collector) (*dns.Msg, error) {
 	buf, err := m.Pack()
 	if err != nil {
 		return nil, errorx.Decorate(err, "couldn't pack request msg")
 	}
+	collector.QuerySize(len(buf))
 
 	// It appears, that GET requests are more memory-efficient with Golang
 	// implementation of HTTP/2.
-	requestURL := c.baseClient.URL.String() + "?dns=" + base64.RawURLEncoding.EncodeToString(buf)
-	req, err := http.NewRequest("GET", requestURL, nil)
+	requestURL := upstreamURL + "?dns=" + base64.RawURLEncoding.EncodeToString(buf)
+	req, err := http.NewRequestWithContext(ctx, "GET", requestURL, nil)
 	if err != nil {
 		return nil, err
 	}
 	req.Header.Set("Accept", "application/dns-message")
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if info.Conn != nil {
+				collector.RemoteAddress(info.Conn.RemoteAddr().String())
+			}
+			if tlsConn, ok := info.Conn.(*tls.Conn); ok {
+				c.recordTLSState(tlsConn.ConnectionState(), collector)
+			}
+			if info.Reused {
+				collector.ConnectionReused()
+			}
+		},
+	}))
 
 	collector.QuerySend()
 	resp, err := client.Do(req)
@@ -60,9 +83,11 @@ func (c *DoHClient) exchangeHTTPSClient(m *dns.Msg, client *http.Client, collect
 	if err != nil {
 		return nil, err
 	}
+	collector.ResponseSize(len(body))
 	collector.HTTPVersion(resp.Proto)
+	collector.HTTPStatus(resp.StatusCode)
 	if resp.StatusCode != http.StatusOK {
-		return nil, err
+		return nil, fmt.Errorf("got status code %d from %s", resp.StatusCode, upstreamURL)
 	}
 	response := dns.Msg{}
 	err = response.Unpack(body)
@@ -80,27 +105,59 @@ func (c *DoHClient) exchangeHTTPSClient(m *dns.Msg, client *http.Client, collect
 }
 
 func (c *DoHClient) Exchange(m *dns.Msg) *metrics.WithResponseOrError {
+	return c.ExchangeContext(context.Background(), m)
+}
+
+func (c *DoHClient) ExchangeContext(ctx context.Context, m *dns.Msg) *metrics.WithResponseOrError {
+	if c.baseClient.options.ReuseConnection {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+	}
+
 	collector := &metrics.Collector{}
+	result := c.exchange(ctx, m, collector)
+	handleContextError(ctx, result.GetError(), collector)
+	return result
+}
+
+func (c *DoHClient) exchange(ctx context.Context, m *dns.Msg, collector *metrics.Collector) *metrics.WithResponseOrError {
 	collector.ExchangeStarted()
-	client := c.createClient(collector)
-	reply, err := c.exchangeHTTPSClient(m, client, collector)
+	client := c.getClient(collector)
+	if !c.baseClient.options.ReuseConnection {
+		defer client.CloseIdleConnections()
+	}
+	reply, err := c.baseClient.exchangeHTTPSClient(ctx, c.baseClient.URL.String(), c.baseClient.padQuery(m), client, collector)
 	if err != nil {
 		return collector.WithError(err)
 	}
 	return collector.WithResponse(reply)
 }
 
-func (c *DoHClient) wrappedTLSDial(collector *metrics.Collector) func(context context.Context, network string, addr string) (net.Conn, error) {
-	tlsDial := c.baseClient.getTLSDialContext(collector)
+// getClient returns the client kept between exchanges when Options.ReuseConnection is set, otherwise a new one
+func (c *DoHClient) getClient(collector *metrics.Collector) *http.Client {
+	if !c.baseClient.options.ReuseConnection {
+		return c.createClient(newCollectorHolder(collector))
+	}
+
+	if c.client == nil {
+		c.collector = newCollectorHolder(collector)
+		c.client = c.createClient(c.collector)
+	} else {
+		c.collector.set(collector)
+	}
+	return c.client
+}
 
+func (c *DoHClient) wrappedTLSDial(holder *collectorHolder) func(context context.Context, network string, addr string) (net.Conn, error) {
 	return func(context context.Context, network string, addr string) (net.Conn, error) {
-		conn, err := tlsDial(context, network, addr)
+		collector := holder.get()
+		conn, err := c.baseClient.getTLSDialContext(collector)(context, network, addr)
 		collector.QuerySend()
 		return conn, err
 	}
 }
 
-func (c *DoHClient) createTransport(collector *metrics.Collector) *WrappedTransport {
+func (c *DoHClient) createTransport(collector *collectorHolder) *WrappedTransport {
 	tlsConfig := c.baseClient.resolvedConfig
 
 	transport := &http.Transport{
@@ -118,7 +175,7 @@ func (c *DoHClient) createTransport(collector *metrics.Collector) *WrappedTransp
 	return &WrappedTransport{collector: collector, transport: transport}
 }
 
-func (c *DoHClient) createClient(collector *metrics.Collector) *http.Client {
+func (c *DoHClient) createClient(collector *collectorHolder) *http.Client {
 	transport := c.createTransport(collector)
 
 	client := &http.Client{
@@ -0,0 +1,141 @@
+package clients
+
+import (
+	"context"
+	"crypto/tls"
+	"github.com/lucas-clemente/quic-go"
+	"github.com/lucas-clemente/quic-go/http3"
+	"github.com/mgranderath/dnsperf/metrics"
+	"github.com/miekg/dns"
+	"net/http"
+	"sync"
+)
+
+// http3Version is the HTTP version reported for DNS-over-HTTP/3 exchanges
+const http3Version = "HTTP/3"
+
+type DoH3Client struct {
+	baseClient *baseClient
+
+	// conn is kept between exchanges when Options.ReuseConnection is set
+	mutex sync.Mutex
+	conn  *doh3Conn
+}
+
+// doh3Conn is a http3 transport together with the QUIC connection it dialed and the trace of the connection
+type doh3Conn struct {
+	transport *http3.RoundTripper
+	session   quic.EarlyConnection
+	trace     *quicTrace
+	collector *collectorHolder
+}
+
+// requestURL returns the upstream URL with the h3 scheme replaced by https so that http3 accepts it
+func (c *DoH3Client) requestURL() string {
+	u := *c.baseClient.URL
+	u.Scheme = "https"
+	return u.String()
+}
+
+func (c *DoH3Client) Exchange(m *dns.Msg) *metrics.WithResponseOrError {
+	return c.ExchangeContext(context.Background(), m)
+}
+
+func (c *DoH3Client) ExchangeContext(ctx context.Context, m *dns.Msg) *metrics.WithResponseOrError {
+	if c.baseClient.options.ReuseConnection {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+	}
+
+	collector := metrics.NewCollector()
+	result := c.exchange(ctx, m, collector)
+	handleContextError(ctx, result.GetError(), collector)
+	return result
+}
+
+func (c *DoH3Client) exchange(ctx context.Context, m *dns.Msg, collector *metrics.Collector) *metrics.WithResponseOrError {
+	conn := c.getConnection(collector)
+
+	collector.ExchangeStarted()
+	if conn.session != nil {
+		collector.ConnectionReused()
+		collector.RemoteAddress(conn.session.RemoteAddr().String())
+		c.baseClient.recordTLSState(conn.session.ConnectionState().TLS.ConnectionState, collector)
+	}
+
+	client := &http.Client{
+		Transport: &WrappedTransport{collector: conn.collector, transport: conn.transport},
+		Timeout:   c.baseClient.options.Timeout,
+		Jar:       nil,
+	}
+
+	reply, err := c.baseClient.exchangeHTTPSClient(ctx, c.requestURL(), c.baseClient.padQuery(m), client, collector)
+	if conn.session != nil {
+		conn.trace.record(collector)
+	}
+	c.releaseConnection(conn, err != nil)
+	if err != nil {
+		return collector.WithError(err)
+	}
+	collector.HTTPVersion(http3Version)
+	return collector.WithResponse(reply)
+}
+
+// getConnection returns the connection kept between exchanges when Options.ReuseConnection is set, otherwise a new one.
+// A kept connection whose QUIC connection was closed, e.g. by an idle timeout, is replaced by a new one.
+func (c *DoH3Client) getConnection(collector *metrics.Collector) *doh3Conn {
+	if c.conn != nil && c.conn.session != nil && c.conn.session.Context().Err() != nil {
+		_ = c.conn.transport.Close()
+		c.conn = nil
+		collector.ConnectionRedialed()
+	}
+	if c.conn != nil {
+		c.conn.collector.set(collector)
+		return c.conn
+	}
+
+	conn := &doh3Conn{collector: newCollectorHolder(collector)}
+	conn.transport = c.createTransport(conn)
+	if c.baseClient.options.ReuseConnection {
+		c.conn = conn
+	}
+	return conn
+}
+
+// releaseConnection closes the connection unless it is kept for the next exchange, failed connections are never kept
+func (c *DoH3Client) releaseConnection(conn *doh3Conn, failed bool) {
+	if c.baseClient.options.ReuseConnection && !failed {
+		return
+	}
+	_ = conn.transport.Close()
+	if c.conn == conn {
+		c.conn = nil
+	}
+}
+
+func (c *DoH3Client) createTransport(conn *doh3Conn) *http3.RoundTripper {
+	quicConfig := c.baseClient.getQUICConfig()
+
+	// http3 can only dial a single QUIC version, so we use the most preferred one
+	if len(quicConfig.Versions) > 1 {
+		quicConfig.Versions = quicConfig.Versions[:1]
+	}
+
+	return &http3.RoundTripper{
+		DisableCompression: true,
+		TLSClientConfig:    c.baseClient.resolvedConfig,
+		QuicConfig:         quicConfig,
+		// Note that we're using the bootstrapped addresses instead of what's passed to the function
+		Dial: func(ctx context.Context, _ string, tlsConfig *tls.Config, quicConfig *quic.Config) (quic.EarlyConnection, error) {
+			collector := conn.collector.get()
+			session, trace, err := c.baseClient.dialQUICAddresses(ctx, tlsConfig, quicConfig, conn.collector, collector)
+			if err != nil {
+				return nil, err
+			}
+			conn.session = session
+			conn.trace = trace
+			collector.QuerySend()
+			return session, nil
+		},
+	}
+}
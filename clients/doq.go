@@ -2,125 +2,346 @@ package clients
 
 import (
 	"context"
+	"crypto/tls"
+	"encoding/binary"
 	"errors"
+	"fmt"
 	"github.com/lucas-clemente/quic-go"
 	"github.com/lucas-clemente/quic-go/logging"
 	"github.com/lucas-clemente/quic-go/qlog"
+	"github.com/mgranderath/dnsperf/doqerr"
 	"github.com/mgranderath/dnsperf/metrics"
 	"github.com/mgranderath/dnsperf/qerr"
 	"github.com/miekg/dns"
 	"io"
-	"net"
-	"reflect"
 	"sync"
 	"time"
-	"fmt"
 )
 
 type DoQVersion string
 
 const (
-	VersionDoQ00 DoQVersion = "doq-i00"
-	VersionDoQ01 DoQVersion = "doq-i01"
-	VersionDoQ02 DoQVersion = "doq-i02"
-	VersionDoQ03 DoQVersion = "doq-i03"
-	VersionDoQ04 DoQVersion = "doq-i04"
-	VersionDoQ05 DoQVersion = "doq-i05"
-	VersionDoQ06 DoQVersion = "doq-i06"
-	VersionDoQ07 DoQVersion = "doq-i07"
-	VersionDoQ08 DoQVersion = "doq-i08"
-	VersionDoQ09 DoQVersion = "doq-i09"
+	VersionDoQ00  DoQVersion = "doq-i00"
+	VersionDoQ01  DoQVersion = "doq-i01"
+	VersionDoQ02  DoQVersion = "doq-i02"
+	VersionDoQ03  DoQVersion = "doq-i03"
+	VersionDoQ04  DoQVersion = "doq-i04"
+	VersionDoQ05  DoQVersion = "doq-i05"
+	VersionDoQ06  DoQVersion = "doq-i06"
+	VersionDoQ07  DoQVersion = "doq-i07"
+	VersionDoQ08  DoQVersion = "doq-i08"
+	VersionDoQ09  DoQVersion = "doq-i09"
 	VersionDoQRFC DoQVersion = "doq"
 )
 
 var defaultDoQVersions = []DoQVersion{VersionDoQRFC, VersionDoQ09, VersionDoQ08, VersionDoQ07, VersionDoQ06, VersionDoQ05, VersionDoQ04, VersionDoQ03, VersionDoQ02, VersionDoQ01, VersionDoQ00}
 
+// lengthPrefixedDoQVersions prefix each message with a 2-octet length field (RFC 9250 section 4.2), the field was
+// introduced in draft-ietf-dprive-dnsoquic-07, earlier drafts send the bare message and end the stream
+var lengthPrefixedDoQVersions = map[DoQVersion]bool{
+	VersionDoQRFC: true,
+	VersionDoQ09:  true,
+	VersionDoQ08:  true,
+	VersionDoQ07:  true,
+}
+
+// ErrDoQFramingMismatch is returned when the framing of a response does not match the negotiated DoQ version,
+// e.g. a server negotiating "doq" that answers without length prefix like the early drafts
+var ErrDoQFramingMismatch = errors.New("DoQ response framing does not match the negotiated protocol")
+
 const handshakeTimeout = time.Second * 2
 
 type DoQClient struct {
 	baseClient *baseClient
+
+	// session, its trace and collector are kept between exchanges when Options.ReuseConnection is set
+	mutex     sync.Mutex
+	session   quic.Connection
+	trace     *quicTrace
+	collector *collectorHolder
+}
+
+// quicTrace is what the tracers of a QUIC connection recorded, it is kept with the connection for the exchanges
+// that reuse it. session is nil if the connection could not be established.
+type quicTrace struct {
+	session quic.Connection
+	stats   *quicConnectionStats
+	qlog    *attemptQLog
+}
+
+// record passes the state, the statistics and the qlog file of the connection to collector, t is nil if no
+// connection was dialed
+func (t *quicTrace) record(collector *metrics.Collector) {
+	if t == nil {
+		return
+	}
+	if t.session != nil {
+		collector.QUICConnectionInfo(t.session.ConnectionInfo())
+	}
+	t.stats.record(collector)
+	t.qlog.record(collector)
 }
 
-type qLogWriter struct {
-	collector *metrics.Collector
+// attemptQLog buffers the qlog output of a connection attempt until it is known whether the attempt won
+// the race against the attempts to other addresses, only the output of the winner reaches the collector.
+// With QuicOptions.QLogDir the output is written to a file instead, the files of attempts that lost are removed.
+type attemptQLog struct {
+	mutex    sync.Mutex
+	holder   *collectorHolder
+	decided  bool
+	won      bool
+	buffered [][]byte
+
+	// files are set when the output goes to QuicOptions.QLogDir, the last one belongs to the current connection,
+	// err is set if a file could not be created
+	files []*qlogFile
+	err   error
 }
 
-func (w qLogWriter) Write(p []byte) (n int, err error) {
+// open returns the writer of the qlog of the connection with the original destination connection ID odcid, or nil
+// if its file could not be created, which disables the qlog of the connection
+func (w *attemptQLog) open(c *baseClient, odcid []byte) io.WriteCloser {
+	if c.options.QuicOptions == nil || c.options.QuicOptions.QLogDir == "" {
+		return w
+	}
+
+	file, err := c.createQLogFile(odcid)
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if err != nil {
+		w.err = err
+		return nil
+	}
+	// quic-go recreates the connection after version negotiation without closing the tracer of the first one, so
+	// its file is closed here
+	if n := len(w.files); n != 0 {
+		_ = w.files[n-1].Close()
+	}
+	w.files = append(w.files, file)
+	if w.decided && !w.won {
+		file.drop()
+	}
+	return file
+}
+
+func (w *attemptQLog) Write(p []byte) (n int, err error) {
 	if string(p[:]) == "\n" {
 		return 0, nil
 	}
-	w.collector.QLogMessage(p)
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	switch {
+	case !w.decided:
+		w.buffered = append(w.buffered, append([]byte(nil), p...))
+	case w.won:
+		w.holder.get().QLogMessage(p)
+	}
 	return len(p), nil
 }
 
-func (w qLogWriter) Close() error {
+func (w *attemptQLog) Close() error {
 	return nil
 }
 
-func newWriterCloser(collector *metrics.Collector) io.WriteCloser {
-	return &qLogWriter{collector: collector}
+// decide passes the buffered and all further output to the collector if the attempt won, otherwise drops it
+func (w *attemptQLog) decide(won bool) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.decided = true
+	w.won = won
+	if !won {
+		// the connection of a lost attempt is closed, but its tracer may not close the file
+		for _, file := range w.files {
+			file.drop()
+			_ = file.Close()
+		}
+	}
+	if won {
+		for _, message := range w.buffered {
+			w.holder.get().QLogMessage(message)
+		}
+	}
+	w.buffered = nil
 }
 
-func (c *DoQClient) getConnection(collector *metrics.Collector) (quic.Connection, error) {
-	tlsConfig := c.baseClient.resolvedConfig
-	dialContext := c.baseClient.getDialContext(nil)
-	tokenStore := c.baseClient.options.QuicOptions.TokenStore
-	quicVersions := c.baseClient.options.QuicOptions.QuicVersions
-	port := c.baseClient.options.QuicOptions.LocalPort
-
-	// we're using bootstrapped address instead of what's passed to the function
-	// it does not create an actual connection, but it helps us determine
-	// what IP is actually reachable (when there're v4/v6 addresses)
-	rawConn, err := dialContext(context.TODO(), "udp", "")
+// record passes the path of the qlog file of the current connection and the errors creating or writing the files
+// to collector
+func (w *attemptQLog) record(collector *metrics.Collector) {
+	w.mutex.Lock()
+	files, err := w.files, w.err
+	w.mutex.Unlock()
+
 	if err != nil {
-		return nil, fmt.Errorf("Cannot bootstrap address: %v:", err)
+		collector.QLogError(err)
 	}
-	// It's never actually used
-	_ = rawConn.Close()
-
-	udpConn, ok := rawConn.(*net.UDPConn)
-	if !ok {
-		return nil, err
+	for i, file := range files {
+		path, err := file.result()
+		if i == len(files)-1 {
+			collector.QLogFile(path)
+		}
+		if err != nil {
+			collector.QLogError(err)
+		}
 	}
+}
 
-	addr := udpConn.RemoteAddr().String()
+// getQUICConfig creates the quic.Config for a single connection, the qlog tracer is set per connection attempt.
+func (c *baseClient) getQUICConfig() *quic.Config {
 	quicConfig := &quic.Config{
 		HandshakeIdleTimeout: handshakeTimeout,
-		Versions:             quicVersions,
-		Tracer: qlog.NewTracer(func(p logging.Perspective, connectionID []byte) io.WriteCloser {
-			return newWriterCloser(collector)
-		}),
-		TokenStore: tokenStore,
 	}
 
-	// Moved here because code above is misc
-	collector.ExchangeStarted()
+	if c.options.QuicOptions != nil {
+		quicConfig.Versions = c.options.QuicOptions.QuicVersions
+		quicConfig.TokenStore = c.options.QuicOptions.TokenStore
+	}
+
+	return quicConfig
+}
+
+// dialQUICAddresses connects to the resolved addresses according to Options.DialPolicy and returns the first
+// connection established together with its trace, the qlog output of the winning attempt is fed into the
+// collector of holder unless it is written to a file.
+func (c *baseClient) dialQUICAddresses(ctx context.Context, tlsConfig *tls.Config, quicConfig *quic.Config, holder *collectorHolder, collector *metrics.Collector) (quic.EarlyConnection, *quicTrace, error) {
+	addrs, err := c.dialAddresses(ctx, collector)
+	if err != nil {
+		return nil, nil, err
+	}
+	c.lookupTLSA(ctx, collector)
+
+	// every attempt records into its own collector, only the one of the attempt that won is kept
+	attempts := make([]*metrics.Collector, len(addrs))
+	qlogs := make([]*attemptQLog, len(addrs))
+	stats := make([]*quicConnectionStats, len(addrs))
+	for i := range addrs {
+		attempts[i] = metrics.NewCollector()
+		qlogs[i] = &attemptQLog{holder: holder}
+		stats[i] = &quicConnectionStats{}
+	}
+
+	index, session, failed, err := c.raceAttempts(ctx, addrs, true, func(ctx context.Context, i int) (interface{}, error) {
+		attemptConfig := quicConfig.Clone()
+		attemptConfig.Tracer = logging.NewMultiplexedTracer(qlog.NewTracer(func(p logging.Perspective, connectionID []byte) io.WriteCloser {
+			return qlogs[i].open(c, connectionID)
+		}), stats[i].tracer())
+		// tickets usually arrive after the handshake, when the collector of the attempt has been adopted already
+		return c.dialQUIC(ctx, addrs[i], c.recordSessionTickets(tlsConfig, stats[i], collector), attemptConfig, attempts[i])
+	}, func(session interface{}) {
+		_ = session.(quic.EarlyConnection).CloseWithError(0, "")
+	})
+
+	for i := range qlogs {
+		qlogs[i].decide(i == index)
+	}
+	trace := &quicTrace{stats: stats[index], qlog: qlogs[index]}
+	collector.DialAttemptsFailed(failed)
+	collector.AdoptConnectionAttempt(attempts[index])
+	collector.RemoteAddress(addrs[index])
+	if err != nil {
+		trace.record(collector)
+		c.dialFailed(ctx)
+		return nil, nil, err
+	}
+	trace.session = session.(quic.EarlyConnection)
+	return session.(quic.EarlyConnection), trace, nil
+}
+
+// dialQUIC performs the QUIC handshake with addr and records the handshake metrics.
+func (c *baseClient) dialQUIC(ctx context.Context, addr string, tlsConfig *tls.Config, quicConfig *quic.Config, collector *metrics.Collector) (quic.EarlyConnection, error) {
+	port := 0
+	if c.options.QuicOptions != nil {
+		port = c.options.QuicOptions.LocalPort
+	}
 
 	collector.QUICHandshakeStart()
-	session, err := quic.DialAddrEarlyContext(context.Background(), addr, tlsConfig, quicConfig, port)
+	session, err := quic.DialAddrEarlyContext(ctx, addr, c.getConnectionTLSConfig(tlsConfig, collector), quicConfig, port)
 	if err != nil {
-		reflectErr := reflect.ValueOf(err)
-		if reflectErr.IsValid() && reflectErr.Elem().Type().String() == "qerr.QuicError" {
-			errorCode := reflectErr.Elem().FieldByName("ErrorCode").Uint()
-			collector.QUICError(qerr.ErrorCode(errorCode))
-		}
-		return nil, fmt.Errorf("QUIC handshake failed: %v:", err)
+		handleQUICError(err, collector)
+		return nil, fmt.Errorf("QUIC handshake failed: %w", err)
 	}
 	collector.QUICHandshakeDone()
 	collector.TLSVersion(session.ConnectionState().TLS.Version)
+	c.recordTLSState(session.ConnectionState().TLS.ConnectionState, collector)
 	collector.QUICNegotiatedProtocol(session.ConnectionState().TLS.NegotiatedProtocol)
-	collector.QUICVersion(reflect.ValueOf(session).Elem().FieldByName("version").Uint())
+	collector.QUICVersion(uint64(session.ConnectionInfo().Version))
 
 	return session, nil
 }
 
-func (c *DoQClient) openStream(session quic.Connection) (quic.Stream, error) {
-	ctx := context.Background()
+// handleQUICError records the error code if err was caused by a QUIC transport error
+func handleQUICError(err error, collector *metrics.Collector) {
+	var transportErr *quic.TransportError
+	if errors.As(err, &transportErr) {
+		collector.QUICError(qerr.ErrorCode(transportErr.ErrorCode))
+	}
+}
+
+// handleDoQError records the QUIC transport error or the DoQ error code the server reset the stream or closed
+// the connection with
+func handleDoQError(err error, collector *metrics.Collector) {
+	handleQUICError(err, collector)
+
+	var streamErr *quic.StreamError
+	var appErr *quic.ApplicationError
+	switch {
+	case errors.As(err, &streamErr):
+		collector.DoQError(doqerr.ErrorCode(streamErr.ErrorCode), doqerr.SourceStream)
+	case errors.As(err, &appErr) && appErr.Remote:
+		collector.DoQError(doqerr.ErrorCode(appErr.ErrorCode), doqerr.SourceConnection)
+	}
+}
+
+func (c *DoQClient) getConnection(ctx context.Context, collector *metrics.Collector) (quic.Connection, *quicTrace, error) {
+	if c.session != nil {
+		if c.session.Context().Err() == nil {
+			c.collector.set(collector)
+			collector.ExchangeStarted()
+			collector.ConnectionReused()
+			collector.RemoteAddress(c.session.RemoteAddr().String())
+			c.baseClient.recordTLSState(c.session.ConnectionState().TLS.ConnectionState, collector)
+			return c.session, c.trace, nil
+		}
+		c.session = nil
+		c.trace = nil
+		collector.ConnectionRedialed()
+	}
+
+	tlsConfig := c.baseClient.resolvedConfig
+
+	holder := newCollectorHolder(collector)
+	quicConfig := c.baseClient.getQUICConfig()
+
+	collector.ExchangeStarted()
+
+	session, trace, err := c.baseClient.dialQUICAddresses(ctx, tlsConfig, quicConfig, holder, collector)
+	if err != nil {
+		return nil, nil, err
+	}
+	if c.baseClient.options.ReuseConnection {
+		c.session = session
+		c.trace = trace
+		c.collector = holder
+	}
+	return session, trace, nil
+}
+
+// releaseConnection closes the session unless it is kept for the next exchange, failed sessions are never kept
+func (c *DoQClient) releaseConnection(session quic.Connection, failed bool) {
+	if c.baseClient.options.ReuseConnection && !failed {
+		return
+	}
+	_ = session.CloseWithError(quic.ApplicationErrorCode(doqerr.NoError), "")
+	if c.session == session {
+		c.session = nil
+		c.trace = nil
+	}
+}
 
-	if c.baseClient.options.Timeout > 0 {
-		deadline := time.Now().Add(c.baseClient.options.Timeout)
+func (c *DoQClient) openStream(ctx context.Context, session quic.Connection) (quic.Stream, error) {
+	if deadline := c.baseClient.getDeadline(ctx); !deadline.IsZero() {
 		var cancel context.CancelFunc
-		ctx, cancel = context.WithDeadline(context.Background(), deadline)
+		ctx, cancel = context.WithDeadline(ctx, deadline)
 		defer cancel() // avoid resource leak
 	}
 
@@ -130,17 +351,68 @@ func (c *DoQClient) openStream(session quic.Connection) (quic.Stream, error) {
 func (c *DoQClient) getBytesPool() *sync.Pool {
 	return &sync.Pool{
 		New: func() interface{} {
-			return make([]byte, dns.MaxMsgSize)
+			// room for the length prefix and one more byte to detect oversized responses
+			return make([]byte, 2+dns.MaxMsgSize+1)
 		},
 	}
 }
 
+// readStream reads from stream until FIN, so that responses spanning several reads are complete
+func readStream(stream quic.Stream, buf []byte) (int, error) {
+	n := 0
+	for n < len(buf) {
+		read, err := stream.Read(buf[n:])
+		n += read
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, errors.New("response exceeds the maximum DNS message size")
+}
+
+// unframeDoQResponse returns the DNS message of a response read until FIN. Messages never have an ID other
+// than zero in DoQ, so a leading non-zero ID that equals the remaining length is a length prefix.
+func unframeDoQResponse(data []byte, lengthPrefixed bool) ([]byte, error) {
+	prefixMatches := len(data) >= 2 && int(binary.BigEndian.Uint16(data)) == len(data)-2
+	if lengthPrefixed {
+		if !prefixMatches {
+			return nil, fmt.Errorf("%w: expected a 2-octet length prefix on a %d byte response", ErrDoQFramingMismatch, len(data))
+		}
+		return data[2:], nil
+	}
+	if prefixMatches && len(data) > 2 {
+		return nil, fmt.Errorf("%w: unexpected 2-octet length prefix on a %d byte response", ErrDoQFramingMismatch, len(data))
+	}
+	return data, nil
+}
+
 func (c *DoQClient) Exchange(m *dns.Msg) *metrics.WithResponseOrError {
+	return c.ExchangeContext(context.Background(), m)
+}
+
+func (c *DoQClient) ExchangeContext(ctx context.Context, m *dns.Msg) *metrics.WithResponseOrError {
+	if c.baseClient.options.ReuseConnection {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+	}
+
 	collector := &metrics.Collector{}
-	session, err := c.getConnection(collector)
+	result := c.exchange(ctx, m, collector)
+	handleContextError(ctx, result.GetError(), collector)
+	return result
+}
+
+func (c *DoQClient) exchange(ctx context.Context, m *dns.Msg, collector *metrics.Collector) *metrics.WithResponseOrError {
+	m = c.baseClient.padQuery(m)
+
+	session, trace, err := c.getConnection(ctx, collector)
 	if err != nil {
-		return collector.WithError(fmt.Errorf("Cannot start session: %v", err))
+		return collector.WithError(fmt.Errorf("Cannot start session: %w", err))
 	}
+	defer trace.record(collector)
 
 	// If any message sent on a DoQ connection contains an edns-tcp-keepalive EDNS(0) Option,
 	// this is a fatal error and the recipient of the defective message MUST forcibly abort
@@ -150,7 +422,7 @@ func (c *DoQClient) Exchange(m *dns.Msg) *metrics.WithResponseOrError {
 		for _, option := range opt.Option {
 			// Check for EDNS TCP keepalive option
 			if option.Option() == dns.EDNS0TCPKEEPALIVE {
-				_ = session.CloseWithError(0, "") // Already closing the connection so we don't care about the error
+				c.releaseConnection(session, true) // Already closing the connection so we don't care about the error
 				return collector.WithError(errors.New("EDNS0 TCP keepalive option is set"))
 			}
 		}
@@ -169,20 +441,34 @@ func (c *DoQClient) Exchange(m *dns.Msg) *metrics.WithResponseOrError {
 		}
 	}()
 
-	stream, err := c.openStream(session)
+	stream, err := c.openStream(ctx, session)
 	if err != nil {
-		return collector.WithError(fmt.Errorf("Cannot open stream: %v", err))
+		handleDoQError(err, collector)
+		c.releaseConnection(session, true)
+		return collector.WithError(fmt.Errorf("Cannot open stream: %w", err))
 	}
 
+	_ = stream.SetDeadline(c.baseClient.getDeadline(ctx))
+	defer watchContext(ctx, stream)()
+
 	buf, err := m.Pack()
 	if err != nil {
-		collector.WithError(err)
+		c.releaseConnection(session, true)
+		return collector.WithError(err)
+	}
+	collector.QuerySize(len(buf))
+
+	lengthPrefixed := lengthPrefixedDoQVersions[DoQVersion(session.ConnectionState().TLS.NegotiatedProtocol)]
+	if lengthPrefixed {
+		buf = append([]byte{byte(len(buf) >> 8), byte(len(buf))}, buf...)
 	}
 
 	collector.QuerySend()
 	_, err = stream.Write(buf)
 	if err != nil {
-		collector.WithError(fmt.Errorf("Cannot write to stream: %v", err))
+		handleDoQError(err, collector)
+		c.releaseConnection(session, true)
+		return collector.WithError(fmt.Errorf("Cannot write to stream: %w", err))
 	}
 
 	// The client MUST send the DNS query over the selected stream, and MUST
@@ -196,23 +482,31 @@ func (c *DoQClient) Exchange(m *dns.Msg) *metrics.WithResponseOrError {
 
 	defer pool.Put(respBuf)
 
-	n, err := stream.Read(respBuf)
+	n, err := readStream(stream, respBuf)
 	collector.QueryReceive()
-	if err != nil && n == 0 {
-		collector.WithError(fmt.Errorf("Cannot read from stream: %v", err))
+	if err != nil {
+		handleDoQError(err, collector)
+		c.releaseConnection(session, true)
+		return collector.WithError(fmt.Errorf("Cannot read from stream: %w", err))
 	}
 
+	respMsg, err := unframeDoQResponse(respBuf[:n], lengthPrefixed)
+	if err != nil {
+		collector.DoQFramingMismatch()
+		c.releaseConnection(session, true)
+		return collector.WithError(err)
+	}
+	collector.ResponseSize(len(respMsg))
 	reply = new(dns.Msg)
-	err = reply.Unpack(respBuf)
+	err = reply.Unpack(respMsg)
 	if err != nil {
-		collector.WithError(err)
+		c.releaseConnection(session, true)
+		return collector.WithError(err)
 	}
 
 	collector.ExchangeFinished()
 
-	collector.QUICUsed0RTT(session.ConnectionState().TLS.Used0RTT)
-
-	session.CloseWithError(0, "")
+	c.releaseConnection(session, false)
 
 	return collector.WithResponse(reply)
 }
@@ -0,0 +1,126 @@
+package clients
+
+import (
+	"bytes"
+	"errors"
+	"github.com/lucas-clemente/quic-go"
+	"io"
+	"testing"
+)
+
+func TestUnframeDoQResponse(t *testing.T) {
+	msg := []byte{0, 0, 0x81, 0x80, 0, 1}
+	tests := []struct {
+		name           string
+		data           []byte
+		lengthPrefixed bool
+		want           []byte
+		wantErr        bool
+	}{
+		{"prefixed", append([]byte{0, 6}, msg...), true, msg, false},
+		{"prefixed empty", []byte{0, 0}, true, []byte{}, false},
+		{"prefixed missing", msg, true, nil, true},
+		{"prefixed wrong length", append([]byte{0, 7}, msg...), true, nil, true},
+		{"prefixed too short", []byte{0}, true, nil, true},
+		{"bare", msg, false, msg, false},
+		{"bare unexpected prefix", append([]byte{0, 6}, msg...), false, nil, true},
+		// a bare message of two zero bytes looks like an empty prefixed one, it is passed on to fail unpacking
+		{"bare two bytes", []byte{0, 0}, false, []byte{0, 0}, false},
+		{"bare empty", []byte{}, false, []byte{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := unframeDoQResponse(tt.data, tt.lengthPrefixed)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unframeDoQResponse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if !errors.Is(err, ErrDoQFramingMismatch) {
+					t.Errorf("unframeDoQResponse() error = %v, want %v", err, ErrDoQFramingMismatch)
+				}
+				return
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("unframeDoQResponse() = %x, want %x", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLengthPrefixedDoQVersions(t *testing.T) {
+	tests := []struct {
+		version DoQVersion
+		want    bool
+	}{
+		{VersionDoQRFC, true},
+		{VersionDoQ09, true},
+		{VersionDoQ08, true},
+		{VersionDoQ07, true},
+		{VersionDoQ06, false},
+		{VersionDoQ05, false},
+		{VersionDoQ04, false},
+		{VersionDoQ03, false},
+		{VersionDoQ02, false},
+		{VersionDoQ01, false},
+		{VersionDoQ00, false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.version), func(t *testing.T) {
+			if got := lengthPrefixedDoQVersions[tt.version]; got != tt.want {
+				t.Errorf("lengthPrefixedDoQVersions[%q] = %v, want %v", tt.version, got, tt.want)
+			}
+		})
+	}
+}
+
+// chunkedStream returns its data in reads of at most chunk bytes followed by io.EOF
+type chunkedStream struct {
+	quic.Stream
+	data  []byte
+	chunk int
+}
+
+func (s *chunkedStream) Read(p []byte) (int, error) {
+	if len(s.data) == 0 {
+		return 0, io.EOF
+	}
+	n := s.chunk
+	if n > len(p) {
+		n = len(p)
+	}
+	if n > len(s.data) {
+		n = len(s.data)
+	}
+	copy(p, s.data[:n])
+	s.data = s.data[n:]
+	return n, nil
+}
+
+func TestReadStream(t *testing.T) {
+	tests := []struct {
+		name    string
+		size    int
+		bufSize int
+		chunk   int
+		wantErr bool
+	}{
+		{"single read", 100, 200, 200, false},
+		{"several reads", 100, 200, 7, false},
+		{"fills buffer", 200, 201, 50, false},
+		{"exceeds buffer", 201, 201, 50, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := bytes.Repeat([]byte{0xab}, tt.size)
+			buf := make([]byte, tt.bufSize)
+			n, err := readStream(&chunkedStream{data: data, chunk: tt.chunk}, buf)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readStream() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !bytes.Equal(buf[:n], data) {
+				t.Errorf("readStream() read %d bytes, want %d", n, tt.size)
+			}
+		})
+	}
+}
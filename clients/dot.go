@@ -2,8 +2,10 @@ package clients
 
 import (
 	"context"
+	"crypto/tls"
 	"github.com/mgranderath/dnsperf/metrics"
 	"github.com/miekg/dns"
+	"net"
 	"time"
 )
 
@@ -11,36 +13,63 @@ const dialTimeout = 10 * time.Second
 
 type DoTClient struct {
 	baseClient *baseClient
+	conn       *reusableConn
 }
 
 func (c *DoTClient) Exchange(m *dns.Msg) *metrics.WithResponseOrError {
+	return c.ExchangeContext(context.Background(), m)
+}
+
+func (c *DoTClient) ExchangeContext(ctx context.Context, m *dns.Msg) *metrics.WithResponseOrError {
+	defer c.conn.lock()()
+
 	collector := metrics.NewCollector()
+	result := c.exchange(ctx, m, collector)
+	handleContextError(ctx, result.GetError(), collector)
+	return result
+}
+
+func (c *DoTClient) exchange(ctx context.Context, m *dns.Msg, collector *metrics.Collector) *metrics.WithResponseOrError {
+	m = c.baseClient.padQuery(m)
+
+	c.conn.discardStale(collector)
 	collector.ExchangeStarted()
-	rawCon, err := c.baseClient.getTLSDialContext(collector)(context.TODO(), "tcp", "")
+	rawCon, err := c.conn.get(func() (net.Conn, error) {
+		return c.baseClient.getTLSDialContext(collector)(ctx, "tcp", "")
+	}, collector)
 	if err != nil {
 		return collector.WithError(err)
 	}
+	if tlsConn, ok := rawCon.(*tls.Conn); ok {
+		collector.TLSVersion(tlsConn.ConnectionState().Version)
+		c.baseClient.recordTLSState(tlsConn.ConnectionState(), collector)
+	}
 
 	cn := dns.Conn{Conn: rawCon}
-	_ = cn.SetDeadline(time.Now().Add(c.baseClient.options.Timeout))
+	_ = cn.SetDeadline(c.baseClient.getDeadline(ctx))
+	stopWatching := watchContext(ctx, rawCon)
 
 	collector.QuerySend()
-	err = cn.WriteMsg(m)
+	err = writeMsg(&cn, m, collector)
 	if err != nil {
-		rawCon.Close()
+		stopWatching()
+		c.conn.release(rawCon, true)
 		return collector.WithError(err)
 	}
 
-	reply, err := cn.ReadMsg()
+	reply, err := readMsg(&cn, collector)
 	collector.QueryReceive()
+	stopWatching()
 	if err != nil {
-		rawCon.Close()
+		c.conn.release(rawCon, true)
 		return collector.WithError(err)
 	}
+	// the reply belongs to an earlier query, the one to m would be read by the next exchange
 	if reply.Id != m.Id {
 		err = dns.ErrId
 	}
 
+	c.conn.release(rawCon, err != nil)
 	collector.ExchangeFinished()
 	return collector.WithResponseAndError(reply, err)
 }
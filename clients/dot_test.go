@@ -0,0 +1,96 @@
+package clients
+
+import (
+	"crypto/tls"
+	"errors"
+	"github.com/miekg/dns"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// serveMismatchedIDs answers the first query on ln with a wrong ID and every other query correctly, it returns the
+// number of connections accepted
+func serveMismatchedIDs(t *testing.T, ln net.Listener) *int64 {
+	t.Helper()
+	var connections, queries int64
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt64(&connections, 1)
+			go func() {
+				defer conn.Close()
+				cn := dns.Conn{Conn: conn}
+				for {
+					m, err := cn.ReadMsg()
+					if err != nil {
+						return
+					}
+					reply := new(dns.Msg).SetReply(m)
+					if atomic.AddInt64(&queries, 1) == 1 {
+						reply.Id++
+					}
+					if cn.WriteMsg(reply) != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return &connections
+}
+
+func TestReusedConnectionDiscardedOnIDMismatch(t *testing.T) {
+	certificate, _ := newTestServerCertificate(t)
+
+	tests := []struct {
+		scheme string
+		listen func() (net.Listener, error)
+	}{
+		{"tcp", func() (net.Listener, error) {
+			return net.Listen("tcp", "127.0.0.1:0")
+		}},
+		{"tls", func() (net.Listener, error) {
+			return tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{certificate}})
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.scheme, func(t *testing.T) {
+			ln, err := tt.listen()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ln.Close()
+			connections := serveMismatchedIDs(t, ln)
+
+			client, err := AddressToClient(tt.scheme+"://"+ln.Addr().String(), Options{
+				Timeout:         2 * time.Second,
+				ReuseConnection: true,
+				TLSOptions:      &TLSOptions{InsecureSkipVerify: true},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			m := new(dns.Msg)
+			m.SetQuestion("example.org.", dns.TypeA)
+			if err := client.Exchange(m.Copy()).GetError(); !errors.Is(err, dns.ErrId) {
+				t.Fatalf("Exchange() error = %v, want %v", err, dns.ErrId)
+			}
+			for i := 0; i < 2; i++ {
+				if err := client.Exchange(m.Copy()).GetError(); err != nil {
+					t.Fatalf("Exchange() error = %v", err)
+				}
+			}
+
+			// the connection with the mismatched response is replaced, the next one is reused
+			if got := atomic.LoadInt64(connections); got != 2 {
+				t.Errorf("connections = %d, want 2", got)
+			}
+		})
+	}
+}
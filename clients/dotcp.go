@@ -4,41 +4,68 @@ import (
 	"context"
 	"github.com/mgranderath/dnsperf/metrics"
 	"github.com/miekg/dns"
-	"time"
+	"net"
 )
 
 type DoTCPClient struct {
 	baseClient *baseClient
+	conn       *reusableConn
 }
 
 func (c *DoTCPClient) Exchange(m *dns.Msg) *metrics.WithResponseOrError {
+	return c.ExchangeContext(context.Background(), m)
+}
+
+func (c *DoTCPClient) ExchangeContext(ctx context.Context, m *dns.Msg) *metrics.WithResponseOrError {
+	defer c.conn.lock()()
+
 	collector := metrics.NewCollector()
+	result := c.exchange(ctx, m, collector)
+	handleContextError(ctx, result.GetError(), collector)
+	return result
+}
+
+func (c *DoTCPClient) exchange(ctx context.Context, m *dns.Msg, collector *metrics.Collector) *metrics.WithResponseOrError {
 	dialContext := c.baseClient.getDialContext(collector)
 
+	c.conn.discardStale(collector)
 	collector.ExchangeStarted()
 
-	rawCon, err := dialContext(context.Background(), "tcp", "")
+	rawCon, err := c.conn.get(func() (net.Conn, error) {
+		return dialContext(ctx, "tcp", "")
+	}, collector)
 	if err != nil {
 		return collector.WithError(err)
 	}
 
 	cn := dns.Conn{Conn: rawCon}
-	_ = cn.SetDeadline(time.Now().Add(c.baseClient.options.Timeout))
+	_ = cn.SetDeadline(c.baseClient.getDeadline(ctx))
+	stopWatching := watchContext(ctx, rawCon)
 
 	collector.QuerySend()
-	err = cn.WriteMsg(m)
+	err = writeMsg(&cn, m, collector)
 	if err != nil {
+		stopWatching()
+		c.conn.release(rawCon, true)
 		return collector.WithError(err)
 	}
-	r, err := cn.ReadMsg()
+	r, err := readMsg(&cn, collector)
 	collector.QueryReceive()
+	stopWatching()
 	if err != nil {
+		c.conn.release(rawCon, true)
 		return collector.WithError(err)
 	}
+	// the response to the query may still be on its way, the connection is not reused
+	if r.Id != m.Id {
+		c.conn.release(rawCon, true)
+		collector.ExchangeFinished()
+		return collector.WithResponseAndError(r, dns.ErrId)
+	}
+	c.conn.release(rawCon, false)
+	collector.ExchangeFinished()
 	if r == nil || r.Rcode != dns.RcodeSuccess {
 		return collector.WithResponseAndError(r, err)
 	}
-
-	collector.ExchangeFinished()
 	return collector.WithResponse(r)
 }
@@ -4,42 +4,119 @@ import (
 	"context"
 	"github.com/mgranderath/dnsperf/metrics"
 	"github.com/miekg/dns"
+	"net"
 	"time"
 )
 
+type TruncationPolicy int
+
+const (
+	// TruncationReturn returns a truncated UDP response as it is
+	TruncationReturn TruncationPolicy = iota
+
+	// TruncationRetryTCP repeats the query over TCP to the address that sent the truncated UDP response,
+	// like a stub resolver would (RFC 7766 section 5)
+	TruncationRetryTCP
+)
+
 type DoUDPClient struct {
 	baseClient *baseClient
 }
 
 func (c *DoUDPClient) Exchange(m *dns.Msg) *metrics.WithResponseOrError {
+	return c.ExchangeContext(context.Background(), m)
+}
+
+func (c *DoUDPClient) ExchangeContext(ctx context.Context, m *dns.Msg) *metrics.WithResponseOrError {
 	collector := metrics.NewCollector()
+	result := c.exchange(ctx, m, collector)
+	handleContextError(ctx, result.GetError(), collector)
+	return result
+}
+
+func (c *DoUDPClient) exchange(ctx context.Context, m *dns.Msg, collector *metrics.Collector) *metrics.WithResponseOrError {
 	dialContext := c.baseClient.getDialContext(collector)
 
 	collector.ExchangeStarted()
 
-	rawCon, err := dialContext(context.Background(), "udp", "")
+	rawCon, err := dialContext(ctx, "udp", "")
 	if err != nil {
 		return collector.WithError(err)
 	}
+	defer rawCon.Close()
 
 	cn := dns.Conn{Conn: rawCon}
-	_ = cn.SetDeadline(time.Now().Add(c.baseClient.options.Timeout))
+	// accept responses as large as the query advertises instead of the 512 bytes default
+	if opt := m.IsEdns0(); opt != nil {
+		cn.UDPSize = opt.UDPSize()
+	}
+	deadline := c.baseClient.getDeadline(ctx)
+	_ = cn.SetDeadline(deadline)
+	defer watchContext(ctx, rawCon)()
 
 	collector.QuerySend()
-	err = cn.WriteMsg(m)
+	err = writeMsg(&cn, m, collector)
 	if err != nil {
 		return collector.WithError(err)
 	}
-	r, err := cn.ReadMsg()
+	r, err := readMsg(&cn, collector)
 	collector.QueryReceive()
 	if err != nil {
 		return collector.WithError(err)
 	}
-	if r == nil || r.Rcode != dns.RcodeSuccess {
+	if r.Id != m.Id {
+		collector.ExchangeFinished()
+		return collector.WithResponseAndError(r, dns.ErrId)
+	}
+	if r.Truncated && c.baseClient.options.Truncation == TruncationRetryTCP {
+		return c.exchangeTCP(ctx, m, rawCon.RemoteAddr().String(), deadline, collector)
+	}
+	collector.ExchangeFinished()
+	if r.Rcode != dns.RcodeSuccess {
 		return collector.WithResponseAndError(r, err)
 	}
 
+	return collector.WithResponse(r)
+}
+
+// exchangeTCP repeats the query over a new TCP connection to addr after a truncated UDP response, deadline is the
+// one of the UDP query so that Options.Timeout bounds the whole exchange
+func (c *DoUDPClient) exchangeTCP(ctx context.Context, m *dns.Msg, addr string, deadline time.Time, collector *metrics.Collector) *metrics.WithResponseOrError {
+	collector.TCPFallback()
+
+	dialer := &net.Dialer{
+		Deadline: deadline,
+	}
+	collector.TCPHandshakeStart()
+	rawCon, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return collector.WithError(err)
+	}
+	collector.TCPHandshakeFinished()
+	defer rawCon.Close()
+
+	cn := dns.Conn{Conn: rawCon}
+	_ = cn.SetDeadline(deadline)
+	defer watchContext(ctx, rawCon)()
+
+	collector.QuerySend()
+	err = writeMsg(&cn, m, collector)
+	if err != nil {
+		return collector.WithError(err)
+	}
+	r, err := readMsg(&cn, collector)
+	collector.QueryReceive()
+	if err != nil {
+		return collector.WithError(err)
+	}
+	if r.Id != m.Id {
+		collector.ExchangeFinished()
+		return collector.WithResponseAndError(r, dns.ErrId)
+	}
 	collector.ExchangeFinished()
+	if r.Rcode != dns.RcodeSuccess {
+		return collector.WithResponseAndError(r, err)
+	}
 
 	return collector.WithResponse(r)
 }
@@ -0,0 +1,135 @@
+package clients
+
+import (
+	"context"
+	"github.com/joomcode/errorx"
+	"github.com/mgranderath/dnsperf/metrics"
+	"github.com/miekg/dns"
+	"net"
+	"net/url"
+	"sync"
+)
+
+type FanOutMode int
+
+const (
+	// FanOutSequential sends the query to one address after another
+	FanOutSequential FanOutMode = iota
+
+	// FanOutConcurrent sends the query to all addresses at the same time
+	FanOutConcurrent
+)
+
+// AddressClient is a client that only connects to a single IP address of an upstream
+type AddressClient struct {
+	IP     net.IP
+	Client DnsClient
+}
+
+// FanOutClient sends every query to each IP address of an upstream, so that a single slow or broken node
+// behind an anycast or multi-homed name shows up in the results
+type FanOutClient struct {
+	clients []AddressClient
+	mode    FanOutMode
+}
+
+// AddressToClients is AddressToClientsContext with context.Background() and without a collector
+func AddressToClients(address string, options Options) ([]AddressClient, error) {
+	return AddressToClientsContext(context.Background(), address, options, nil)
+}
+
+// AddressToClientsContext creates a client for every IP address of the upstream, these are Options.ServerIPAddrs if
+// set or all addresses the host of the upstream resolves to with the bootstrap upstreams. ctx bounds the lookup,
+// which is recorded in collector unless it is nil.
+func AddressToClientsContext(ctx context.Context, address string, options Options, collector *metrics.Collector) ([]AddressClient, error) {
+	ips, err := upstreamIPs(ctx, address, options, collector)
+	if err != nil {
+		return nil, err
+	}
+
+	clients := make([]AddressClient, 0, len(ips))
+	for _, ip := range ips {
+		addressOptions := options
+		addressOptions.ServerIPAddrs = []net.IP{ip}
+		client, err := AddressToClient(address, addressOptions)
+		if err != nil {
+			return nil, err
+		}
+		clients = append(clients, AddressClient{IP: ip, Client: client})
+	}
+	return clients, nil
+}
+
+func upstreamIPs(ctx context.Context, address string, options Options, collector *metrics.Collector) ([]net.IP, error) {
+	if len(options.ServerIPAddrs) != 0 {
+		return options.ServerIPAddrs, nil
+	}
+
+	upstreamURL, err := url.Parse(address)
+	if err != nil {
+		return nil, errorx.Decorate(err, "failed to parse %s", address)
+	}
+	host := upstreamURL.Hostname()
+	if upstreamURL.Scheme == "sdns" {
+		stamp, err := ParseDNSCryptStamp(address)
+		if err != nil {
+			return nil, errorx.Decorate(err, "invalid stamp")
+		}
+		host, _, _ = net.SplitHostPort(stamp.ServerAddr)
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+	bootstrap, err := newBootstrapper(host, options)
+	if err != nil {
+		return nil, err
+	}
+	return bootstrap.resolve(ctx, collector)
+}
+
+// NewFanOutClient is NewFanOutClientContext with context.Background() and without a collector
+func NewFanOutClient(address string, options Options, mode FanOutMode) (*FanOutClient, error) {
+	return NewFanOutClientContext(context.Background(), address, options, mode, nil)
+}
+
+// NewFanOutClientContext creates a FanOutClient for all IP addresses of the upstream, see AddressToClientsContext
+func NewFanOutClientContext(ctx context.Context, address string, options Options, mode FanOutMode, collector *metrics.Collector) (*FanOutClient, error) {
+	clients, err := AddressToClientsContext(ctx, address, options, collector)
+	if err != nil {
+		return nil, err
+	}
+	return &FanOutClient{clients: clients, mode: mode}, nil
+}
+
+// Clients returns the clients of the addresses in the order of the results of Exchange
+func (c *FanOutClient) Clients() []AddressClient {
+	return c.clients
+}
+
+func (c *FanOutClient) Exchange(m *dns.Msg) []*metrics.WithResponseOrError {
+	return c.ExchangeContext(context.Background(), m)
+}
+
+// ExchangeContext sends m to every address and returns one result per address, results[i] belongs to Clients()[i]
+func (c *FanOutClient) ExchangeContext(ctx context.Context, m *dns.Msg) []*metrics.WithResponseOrError {
+	results := make([]*metrics.WithResponseOrError, len(c.clients))
+	if c.mode == FanOutSequential {
+		for i, client := range c.clients {
+			results[i] = client.Client.ExchangeContext(ctx, m)
+		}
+		return results
+	}
+
+	var wg sync.WaitGroup
+	for i, client := range c.clients {
+		wg.Add(1)
+		// clients may modify the message while sending it, e.g. DoQ clears the ID
+		go func(i int, client DnsClient, m *dns.Msg) {
+			defer wg.Done()
+			results[i] = client.ExchangeContext(ctx, m)
+		}(i, client.Client, m.Copy())
+	}
+	wg.Wait()
+	return results
+}
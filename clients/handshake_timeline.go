@@ -0,0 +1,37 @@
+package clients
+
+import (
+	"github.com/mgranderath/dnsperf/metrics"
+	"net"
+)
+
+// recordTypeHandshake is the content type of TLS records that carry handshake messages
+const recordTypeHandshake = 22
+
+// handshakeTimelineConn records the first TLS records sent and received on a connection to the handshake
+// timeline, crypto/tls writes the ClientHello first and the first record of the server carries the ServerHello
+type handshakeTimelineConn struct {
+	net.Conn
+	collector *metrics.Collector
+
+	// the connection is only used by the goroutine of the handshake until these are set
+	written bool
+	read    bool
+}
+
+func (c *handshakeTimelineConn) Write(p []byte) (int, error) {
+	if !c.written && len(p) != 0 && p[0] == recordTypeHandshake {
+		c.written = true
+		c.collector.HandshakeEvent(metrics.HandshakeEventTLSClientHelloSent)
+	}
+	return c.Conn.Write(p)
+}
+
+func (c *handshakeTimelineConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if !c.read && n != 0 && p[0] == recordTypeHandshake {
+		c.read = true
+		c.collector.HandshakeEvent(metrics.HandshakeEventTLSServerHelloReceived)
+	}
+	return n, err
+}
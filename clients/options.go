@@ -1,10 +1,10 @@
 package clients
 
 import (
+	"crypto/tls"
 	"github.com/lucas-clemente/quic-go"
 	"net"
 	"time"
-	"crypto/tls"
 )
 
 type TLSOptions struct {
@@ -22,13 +22,43 @@ type TLSOptions struct {
 
 	// optional tls.ClientSessionCache to use (needed for 0RTTs)
 	ClientSessionCache tls.ClientSessionCache
+
+	// Profile selects the RFC 8310 usage profile, InsecureSkipVerify and SkipCommonName are ignored with a profile
+	Profile UsageProfile
+
+	// SPKIPins are base64 encoded SHA-256 hashes of the SubjectPublicKeyInfo of certificates the server is
+	// authenticated with (RFC 7858 section 4.2), one certificate of the chain has to match
+	SPKIPins []string
+
+	// AuthDomainName is the name the certificate is verified against, by default the host of the upstream
+	AuthDomainName string
+
+	// DANEResolver enables DANE (RFC 6698, RFC 7671): the TLSA records of _port._tcp.name (_port._udp.name for DoQ
+	// and DoH3) of the authentication domain name are looked up with it and authenticate the server instead of the
+	// SPKI pins and RootCAs. The resolver has to validate DNSSEC, responses without the AD bit are not trusted.
+	DANEResolver DnsClient
 }
 
 type QuicOptions struct {
 	AllowedVersions *[]DoQVersion
-	TokenStore quic.TokenStore
-	QuicVersions []quic.VersionNumber
+	TokenStore      quic.TokenStore
+	QuicVersions    []quic.VersionNumber
+
+	// LocalPort is the local UDP port of QUIC connections, 0 picks a random port. Concurrent connection attempts
+	// can't bind the same port, so a non-zero port is rejected together with DialHappyEyeballs.
 	LocalPort int
+
+	// QLogDir makes QUIC connections write their qlog to a file in the directory, named by the upstream and the
+	// original destination connection ID, instead of keeping it in metrics.Result.QLogMessages
+	QLogDir string
+
+	// QLogFormat is the format of the files in QLogDir, QLogFormatJSONSeq if it is empty
+	QLogFormat QLogFormat
+}
+
+type DNSCryptOptions struct {
+	// UseTCP - if true, queries are sent over TCP instead of UDP
+	UseTCP bool
 }
 
 type Options struct {
@@ -40,9 +70,34 @@ type Options struct {
 	// Bootstrap DNS servers won't be used at all
 	ServerIPAddrs []net.IP
 
+	// Bootstrap lists the upstreams (any address AddressToClient accepts) that resolve the host of the upstream,
+	// they are tried in order. Without bootstrap upstreams the system resolver is used.
+	// The host is resolved by the first exchange and the addresses are cached until their TTL expires or
+	// dialing them failed.
+	Bootstrap []string
+
+	// DialPolicy selects the order of and the address families used to connect to ServerIPAddrs or
+	// the resolved upstream addresses, by default they are tried one after another
+	DialPolicy DialPolicy
+
 	// TLSOptions can be used to specify the TLS versions to be allowed
 	TLSOptions *TLSOptions
 
 	// QuicOptions can be used to specify the QUIC versions to be allowed
 	QuicOptions *QuicOptions
+
+	// DNSCryptOptions can be used to select the transport of DNSCrypt queries
+	DNSCryptOptions *DNSCryptOptions
+
+	// ReuseConnection - if true, the TCP, TLS, HTTPS and QUIC clients keep their connection open across Exchange calls
+	// so that subsequent queries measure warm-connection latency. Exchanges of a client are serialized in this mode
+	// and a connection that failed is discarded, so the next exchange dials a new one.
+	// The DNSCrypt client keeps its certificate and key pair instead, until the certificate expires.
+	ReuseConnection bool
+
+	// Padding selects the EDNS(0) padding of queries sent over DoT, DoH and DoQ, unencrypted queries are never padded
+	Padding PaddingPolicy
+
+	// Truncation selects what the UDP client does with a truncated response, by default it is returned as it is
+	Truncation TruncationPolicy
 }
@@ -0,0 +1,59 @@
+package clients
+
+import (
+	"github.com/miekg/dns"
+	"math/rand"
+)
+
+type PaddingPolicy int
+
+const (
+	// PaddingNone sends queries as they are
+	PaddingNone PaddingPolicy = iota
+
+	// PaddingBlockLength pads queries to a multiple of 128 bytes as recommended by RFC 8467 section 4.1
+	PaddingBlockLength
+
+	// PaddingRandom adds between 0 and 127 random bytes of padding (RFC 8467 section 4.2)
+	PaddingRandom
+)
+
+// paddingBlockSize is the query block length recommended by RFC 8467
+const paddingBlockSize = 128
+
+// padQuery returns a copy of m carrying an EDNS(0) padding option (RFC 7830) according to Options.Padding.
+// It must only be used by encrypted transports, padding plain text queries hides nothing.
+func (c *baseClient) padQuery(m *dns.Msg) *dns.Msg {
+	if c.options.Padding == PaddingNone {
+		return m
+	}
+
+	padded := m.Copy()
+	opt := padded.IsEdns0()
+	if opt == nil {
+		padded.SetEdns0(dns.DefaultMsgSize, false)
+		opt = padded.IsEdns0()
+	}
+
+	// replace any padding the caller added
+	options := opt.Option[:0]
+	for _, option := range opt.Option {
+		if option.Option() != dns.EDNS0PADDING {
+			options = append(options, option)
+		}
+	}
+	padding := &dns.EDNS0_PADDING{}
+	opt.Option = append(options, padding)
+
+	var length int
+	switch c.options.Padding {
+	case PaddingBlockLength:
+		// Len includes the header of the still empty padding option
+		length = (paddingBlockSize - padded.Len()%paddingBlockSize) % paddingBlockSize
+	case PaddingRandom:
+		length = rand.Intn(paddingBlockSize)
+	}
+	padding.Padding = make([]byte, length)
+
+	return padded
+}
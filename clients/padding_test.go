@@ -0,0 +1,103 @@
+package clients
+
+import (
+	"github.com/miekg/dns"
+	"strings"
+	"testing"
+)
+
+// paddingLengths returns the lengths of the padding options of m
+func paddingLengths(m *dns.Msg) []int {
+	var lengths []int
+	if opt := m.IsEdns0(); opt != nil {
+		for _, option := range opt.Option {
+			if padding, ok := option.(*dns.EDNS0_PADDING); ok {
+				lengths = append(lengths, len(padding.Padding))
+			}
+		}
+	}
+	return lengths
+}
+
+// newPaddingTestQuery returns a query for a name of the given number of 63 byte labels and a label of labelSize
+// bytes below example.com, padding is the length of a padding option added by the caller or -1 for none
+func newPaddingTestQuery(labels int, labelSize int, edns bool, padding int) *dns.Msg {
+	name := strings.Repeat(strings.Repeat("a", 63)+".", labels) + strings.Repeat("b", labelSize) + ".example.com"
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(name), dns.TypeA)
+	if edns {
+		m.SetEdns0(1232, true)
+	}
+	if padding >= 0 {
+		opt := m.IsEdns0()
+		opt.Option = append(opt.Option, &dns.EDNS0_PADDING{Padding: make([]byte, padding)})
+	}
+	return m
+}
+
+func TestPadQueryBlockLength(t *testing.T) {
+	c := &baseClient{options: Options{Padding: PaddingBlockLength}}
+	tests := []struct {
+		name      string
+		labels    int
+		labelSize int
+		edns      bool
+		padding   int
+		wantSize  int
+	}{
+		{"short query", 0, 1, false, -1, 128},
+		{"query with edns", 0, 1, true, -1, 128},
+		{"caller padding replaced", 0, 1, true, 300, 128},
+		// 128 bytes including the OPT record and the header of an empty padding option
+		{"query filling block", 1, 19, false, -1, 128},
+		{"query over block", 1, 20, false, -1, 256},
+		{"caller padding over block", 1, 20, true, 0, 256},
+		{"long query", 3, 50, true, -1, 384},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newPaddingTestQuery(tt.labels, tt.labelSize, tt.edns, tt.padding)
+			before := m.Len()
+
+			padded := c.padQuery(m)
+			if got := padded.Len(); got != tt.wantSize {
+				t.Errorf("padQuery().Len() = %d, want %d", got, tt.wantSize)
+			}
+			packed, err := padded.Pack()
+			if err != nil {
+				t.Fatalf("Pack() error = %v", err)
+			}
+			if len(packed) != tt.wantSize {
+				t.Errorf("len(padQuery().Pack()) = %d, want %d", len(packed), tt.wantSize)
+			}
+			if lengths := paddingLengths(padded); len(lengths) != 1 {
+				t.Errorf("padQuery() padding options = %v, want one", lengths)
+			}
+			if m.Len() != before {
+				t.Errorf("padQuery() modified the query, Len() = %d, want %d", m.Len(), before)
+			}
+			if opt := padded.IsEdns0(); tt.edns && (opt.UDPSize() != 1232 || !opt.Do()) {
+				t.Errorf("padQuery() EDNS(0) = %v, want the options of the query", opt)
+			}
+		})
+	}
+}
+
+func TestPadQueryRandom(t *testing.T) {
+	c := &baseClient{options: Options{Padding: PaddingRandom}}
+	for i := 0; i < 100; i++ {
+		padded := c.padQuery(newPaddingTestQuery(0, 1, false, -1))
+		lengths := paddingLengths(padded)
+		if len(lengths) != 1 || lengths[0] >= paddingBlockSize {
+			t.Fatalf("padQuery() padding options = %v, want one of less than %d bytes", lengths, paddingBlockSize)
+		}
+	}
+}
+
+func TestPadQueryNone(t *testing.T) {
+	c := &baseClient{options: Options{Padding: PaddingNone}}
+	m := newPaddingTestQuery(0, 1, true, -1)
+	if got := c.padQuery(m); got != m {
+		t.Errorf("padQuery() = %v, want the query unchanged", got)
+	}
+}
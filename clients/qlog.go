@@ -0,0 +1,213 @@
+package clients
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+)
+
+// QLogFormat is the format of the qlog files written to QuicOptions.QLogDir
+type QLogFormat string
+
+const (
+	// QLogFormatJSONSeq writes .sqlog files of JSON text sequences (RFC 7464), the streaming format of qlog,
+	// every event is in the file as soon as quic-go emitted it
+	QLogFormatJSONSeq QLogFormat = "sqlog"
+
+	// QLogFormatJSON writes .qlog files holding a single JSON object, the object is only complete once the
+	// connection is closed
+	QLogFormatJSON QLogFormat = "qlog"
+)
+
+// recordSeparator starts every record of a JSON text sequence
+const recordSeparator = 0x1e
+
+var unsafeFileNameChars = regexp.MustCompile(`[^A-Za-z0-9.-]+`)
+
+// qlogFile writes the qlog of a single connection to a file. quic-go emits a header record followed by one record
+// per event, each record is converted to the file format and written as it arrives, so that the memory used does
+// not grow with the length of the connection.
+type qlogFile struct {
+	mutex  sync.Mutex
+	path   string
+	format QLogFormat
+	file   *os.File
+	w      *bufio.Writer
+
+	// header is set once the header record was written, events counts the event records written after it,
+	// err is the first error writing the file, the file is closed then as quic-go stops writing and never closes it
+	header bool
+	events int
+	err    error
+
+	closed  bool
+	discard bool
+}
+
+// createQLogFile creates the qlog file of the connection with the original destination connection ID odcid in
+// QuicOptions.QLogDir, the file is named by the upstream and odcid
+func (c *baseClient) createQLogFile(odcid []byte) (*qlogFile, error) {
+	format := c.options.QuicOptions.QLogFormat
+	switch format {
+	case "":
+		format = QLogFormatJSONSeq
+	case QLogFormatJSONSeq, QLogFormatJSON:
+	default:
+		return nil, fmt.Errorf("unknown qlog format %q", format)
+	}
+
+	upstream := unsafeFileNameChars.ReplaceAllString(c.URL.Scheme+"_"+c.URL.Host, "_")
+	path := filepath.Join(c.options.QuicOptions.QLogDir, fmt.Sprintf("%s_%x.%s", upstream, odcid, format))
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create qlog file: %w", err)
+	}
+	return &qlogFile{
+		path:   path,
+		format: format,
+		file:   file,
+		w:      bufio.NewWriter(file),
+	}, nil
+}
+
+func (f *qlogFile) Write(p []byte) (int, error) {
+	record := bytes.TrimSpace(p)
+	if len(record) == 0 {
+		return len(p), nil
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.err != nil {
+		return 0, f.err
+	}
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+
+	var err error
+	if !f.header {
+		err = f.writeHeader(record)
+		f.header = err == nil
+	} else {
+		err = f.writeEvent(record)
+	}
+	if err != nil {
+		f.err = fmt.Errorf("cannot write qlog file %s: %w", f.path, err)
+		_ = f.close()
+		return 0, f.err
+	}
+	return len(p), nil
+}
+
+// writeHeader writes the header record, which holds the trace and its common fields, in the file format
+func (f *qlogFile) writeHeader(record []byte) error {
+	var header map[string]json.RawMessage
+	if err := json.Unmarshal(record, &header); err != nil {
+		return fmt.Errorf("malformed qlog header: %w", err)
+	}
+
+	if f.format == QLogFormatJSONSeq {
+		header["qlog_format"] = json.RawMessage(`"JSON-SEQ"`)
+		return f.writeRecord(header)
+	}
+
+	// the trace moves into the traces array of the JSON format, its events follow as the records arrive
+	trace := bytes.TrimSpace(header["trace"])
+	if len(trace) < 2 || trace[len(trace)-1] != '}' {
+		return fmt.Errorf("malformed qlog header: trace %q is not an object", trace)
+	}
+	delete(header, "trace")
+	header["qlog_format"] = json.RawMessage(`"JSON"`)
+	encoded, err := json.Marshal(header)
+	if err != nil {
+		return err
+	}
+	f.w.Write(encoded[:len(encoded)-1])
+	f.w.WriteString(`,"traces":[`)
+	f.w.Write(trace[:len(trace)-1])
+	if len(trace) > 2 {
+		f.w.WriteByte(',')
+	}
+	_, err = f.w.WriteString(`"events":[`)
+	return err
+}
+
+func (f *qlogFile) writeEvent(record []byte) error {
+	if !json.Valid(record) {
+		return fmt.Errorf("malformed qlog event %q", record)
+	}
+	f.events++
+
+	if f.format == QLogFormatJSONSeq {
+		return f.writeRecord(json.RawMessage(record))
+	}
+	if f.events > 1 {
+		f.w.WriteByte(',')
+	}
+	_, err := f.w.Write(record)
+	return err
+}
+
+func (f *qlogFile) writeRecord(v interface{}) error {
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	f.w.WriteByte(recordSeparator)
+	f.w.Write(encoded)
+	f.w.WriteByte('\n')
+	// the streaming format is flushed per record so that the file can be inspected while the connection is open
+	return f.w.Flush()
+}
+
+// Close completes the file when the connection is closed, the file is removed if the connection was discarded
+func (f *qlogFile) Close() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.close()
+}
+
+func (f *qlogFile) close() error {
+	if f.closed {
+		return f.err
+	}
+	f.closed = true
+
+	// records are checked before they are written, so the JSON format can be completed after a malformed record
+	if f.header && f.format == QLogFormatJSON {
+		f.w.WriteString("]}]}\n")
+	}
+	if err := f.w.Flush(); err != nil && f.err == nil {
+		f.err = fmt.Errorf("cannot write qlog file %s: %w", f.path, err)
+	}
+	if err := f.file.Close(); err != nil && f.err == nil {
+		f.err = fmt.Errorf("cannot close qlog file %s: %w", f.path, err)
+	}
+	if f.discard {
+		_ = os.Remove(f.path)
+	}
+	return f.err
+}
+
+// drop removes the file of a connection attempt that lost the race against attempts to other addresses
+func (f *qlogFile) drop() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.discard = true
+	if f.closed {
+		_ = os.Remove(f.path)
+	}
+}
+
+// result returns the path of the file and the first error writing it
+func (f *qlogFile) result() (string, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.path, f.err
+}
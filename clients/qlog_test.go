@@ -0,0 +1,98 @@
+package clients
+
+import (
+	"encoding/json"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func mustParseURL(t *testing.T, address string) *url.URL {
+	t.Helper()
+	u, err := url.Parse(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func newTestQLogFile(t *testing.T, format QLogFormat) *qlogFile {
+	t.Helper()
+	c := &baseClient{options: Options{QuicOptions: &QuicOptions{QLogDir: t.TempDir(), QLogFormat: format}}}
+	c.URL = mustParseURL(t, "quic://127.0.0.1:853")
+	f, err := c.createQLogFile([]byte{1, 2, 3, 4})
+	if err != nil {
+		t.Fatalf("createQLogFile() error = %v", err)
+	}
+	return f
+}
+
+func TestQLogFileClosedAfterWriteError(t *testing.T) {
+	f := newTestQLogFile(t, QLogFormatJSON)
+	if filepath.Base(f.path) != "quic_127.0.0.1_853_01020304.qlog" {
+		t.Errorf("path = %s", f.path)
+	}
+
+	records := []string{
+		`{"qlog_version":"draft-02","trace":{"vantage_point":{"type":"client"}}}`,
+		`{"time":1,"name":"transport:packet_sent"}`,
+		`{"time":2,`,
+	}
+	for i, record := range records {
+		_, err := f.Write([]byte(record + "\n"))
+		if (err != nil) != (i == len(records)-1) {
+			t.Fatalf("Write(%d) error = %v", i, err)
+		}
+	}
+	if !f.closed {
+		t.Fatal("file is still open after a write error")
+	}
+	if _, err := f.Write([]byte(records[1])); err == nil {
+		t.Error("Write() after the error succeeded")
+	}
+
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var qlog struct {
+		Traces []struct {
+			Events []json.RawMessage `json:"events"`
+		} `json:"traces"`
+	}
+	if err := json.Unmarshal(data, &qlog); err != nil {
+		t.Fatalf("file is not valid JSON: %v\n%s", err, data)
+	}
+	if len(qlog.Traces) != 1 || len(qlog.Traces[0].Events) != 1 {
+		t.Errorf("file = %s", data)
+	}
+
+	// quic-go does not close the file after the error, dropping it must still remove it
+	f.drop()
+	if _, err := os.Stat(f.path); !os.IsNotExist(err) {
+		t.Errorf("dropped file still exists: %v", err)
+	}
+}
+
+func TestAttemptQLogClosesRecreatedConnections(t *testing.T) {
+	c := &baseClient{options: Options{QuicOptions: &QuicOptions{QLogDir: t.TempDir()}}}
+	c.URL = mustParseURL(t, "quic://127.0.0.1:853")
+
+	w := &attemptQLog{}
+	first := w.open(c, []byte{1}).(*qlogFile)
+	second := w.open(c, []byte{2}).(*qlogFile)
+	if !first.closed {
+		t.Error("file of the recreated connection is still open")
+	}
+
+	w.decide(false)
+	for _, f := range []*qlogFile{first, second} {
+		if !f.closed {
+			t.Errorf("%s is still open", f.path)
+		}
+		if _, err := os.Stat(f.path); !os.IsNotExist(err) {
+			t.Errorf("%s of the lost attempt still exists: %v", f.path, err)
+		}
+	}
+}
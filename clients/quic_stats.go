@@ -0,0 +1,265 @@
+package clients
+
+import (
+	"context"
+	"github.com/lucas-clemente/quic-go/logging"
+	"github.com/mgranderath/dnsperf/metrics"
+	"net"
+	"sync"
+	"time"
+)
+
+// quicConnectionStats computes the metrics.QUICStats and the handshake timeline of a single connection from its
+// tracer events, events arrive from the goroutines of quic-go while the exchange reads the statistics
+type quicConnectionStats struct {
+	mutex            sync.Mutex
+	stats            metrics.QUICStats
+	firstInitialSent time.Time
+
+	// events are the first times of the handshake steps, zeroRTTPackets the packet numbers of the 0-RTT packets,
+	// which share their packet number space with 1-RTT packets
+	events         map[metrics.HandshakeEventName]time.Time
+	zeroRTTPackets map[logging.PacketNumber]struct{}
+
+	// ticketLifetimes are the lifetime hints of the session tickets that were not stored in the session cache yet
+	ticketLifetimes []time.Duration
+}
+
+var _ logging.ConnectionTracer = &quicConnectionStats{}
+
+// tracer returns a logging.Tracer that traces every connection into s, it is used for a single connection attempt
+func (s *quicConnectionStats) tracer() logging.Tracer {
+	return quicStatsTracer{stats: s}
+}
+
+// record passes the statistics collected so far to collector, s may be nil if no connection was dialed
+func (s *quicConnectionStats) record(collector *metrics.Collector) {
+	if s == nil {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	stats := s.stats
+	for _, level := range []**metrics.QUICPacketStats{&stats.Initial, &stats.Handshake, &stats.ZeroRTT, &stats.OneRTT} {
+		if *level != nil {
+			copied := **level
+			*level = &copied
+		}
+	}
+	collector.QUICStats(stats)
+	for name, t := range s.events {
+		collector.HandshakeEventAt(name, t)
+	}
+}
+
+// event records the first time the handshake reached the step name
+func (s *quicConnectionStats) event(name metrics.HandshakeEventName) {
+	if _, ok := s.events[name]; ok {
+		return
+	}
+	if s.events == nil {
+		s.events = make(map[metrics.HandshakeEventName]time.Time)
+	}
+	s.events[name] = time.Now()
+}
+
+// packetStats returns the counters of the encryption level of packetType, or nil for packets without one
+func (s *quicConnectionStats) packetStats(packetType logging.PacketType) *metrics.QUICPacketStats {
+	var level **metrics.QUICPacketStats
+	switch packetType {
+	case logging.PacketTypeInitial:
+		level = &s.stats.Initial
+	case logging.PacketTypeHandshake:
+		level = &s.stats.Handshake
+	case logging.PacketType0RTT:
+		level = &s.stats.ZeroRTT
+	case logging.PacketType1RTT:
+		level = &s.stats.OneRTT
+	default:
+		return nil
+	}
+	if *level == nil {
+		*level = &metrics.QUICPacketStats{}
+	}
+	return *level
+}
+
+// receivedFromServer records the arrival of the first packet of the server
+func (s *quicConnectionStats) receivedFromServer() {
+	if s.stats.FirstServerPacketDelay == nil && !s.firstInitialSent.IsZero() {
+		delay := time.Since(s.firstInitialSent)
+		s.stats.FirstServerPacketDelay = &delay
+	}
+}
+
+func (s *quicConnectionStats) SentPacket(hdr *logging.ExtendedHeader, size logging.ByteCount, _ *logging.AckFrame, _ []logging.Frame) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	packetType := logging.PacketTypeFromHeader(&hdr.Header)
+	switch packetType {
+	case logging.PacketTypeInitial:
+		if s.firstInitialSent.IsZero() {
+			s.firstInitialSent = time.Now()
+		}
+		s.event(metrics.HandshakeEventQUICInitialSent)
+	case logging.PacketType0RTT:
+		s.event(metrics.HandshakeEventQUIC0RTTSent)
+		if s.zeroRTTPackets == nil {
+			s.zeroRTTPackets = make(map[logging.PacketNumber]struct{})
+		}
+		s.zeroRTTPackets[hdr.PacketNumber] = struct{}{}
+	}
+	if stats := s.packetStats(packetType); stats != nil {
+		stats.PacketsSent++
+		stats.BytesSent += uint64(size)
+	}
+}
+
+func (s *quicConnectionStats) ReceivedPacket(hdr *logging.ExtendedHeader, size logging.ByteCount, frames []logging.Frame) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.receivedFromServer()
+	packetType := logging.PacketTypeFromHeader(&hdr.Header)
+	switch packetType {
+	case logging.PacketTypeInitial:
+		s.event(metrics.HandshakeEventQUICInitialReceived)
+	case logging.PacketTypeHandshake:
+		s.event(metrics.HandshakeEventQUICHandshakeReceived)
+	}
+	for _, frame := range frames {
+		if _, ok := frame.(*logging.HandshakeDoneFrame); ok {
+			s.event(metrics.HandshakeEventQUICHandshakeDone)
+		}
+	}
+	if stats := s.packetStats(packetType); stats != nil {
+		stats.PacketsReceived++
+		stats.BytesReceived += uint64(size)
+	}
+}
+
+func (s *quicConnectionStats) ReceivedVersionNegotiationPacket(*logging.Header, []logging.VersionNumber) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.receivedFromServer()
+	s.stats.VersionNegotiationPerformed = true
+}
+
+func (s *quicConnectionStats) ReceivedRetry(*logging.Header) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.receivedFromServer()
+	s.stats.RetryReceived = true
+}
+
+func (s *quicConnectionStats) LostPacket(logging.EncryptionLevel, logging.PacketNumber, logging.PacketLossReason) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.stats.PacketsLost++
+}
+
+// UpdatedPTOCount is called with the number of consecutive probe timeouts, it is reset to zero by acknowledgements
+func (s *quicConnectionStats) UpdatedPTOCount(value uint32) {
+	if value == 0 {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.stats.PTOCount++
+}
+
+// UpdatedKeyFromTLS is called for the keys of both directions, the first of them marks the step
+func (s *quicConnectionStats) UpdatedKeyFromTLS(level logging.EncryptionLevel, _ logging.Perspective) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	switch level {
+	case logging.EncryptionHandshake:
+		s.event(metrics.HandshakeEventQUICHandshakeKeys)
+	case logging.Encryption1RTT:
+		s.event(metrics.HandshakeEventQUIC1RTTKeys)
+	}
+}
+
+// AcknowledgedPacket is called with the encryption level of the ACK frame, 0-RTT packets are acknowledged in 1-RTT
+// packets
+func (s *quicConnectionStats) AcknowledgedPacket(level logging.EncryptionLevel, pn logging.PacketNumber) {
+	if level != logging.Encryption1RTT {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.zeroRTTPackets[pn]; ok {
+		s.event(metrics.HandshakeEventQUIC0RTTAcknowledged)
+	}
+}
+
+func (s *quicConnectionStats) UpdatedMetrics(rttStats *logging.RTTStats, _, _ logging.ByteCount, _ int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if minRTT := rttStats.MinRTT(); minRTT != 0 {
+		s.stats.MinRTT = &minRTT
+	}
+	if smoothedRTT := rttStats.SmoothedRTT(); smoothedRTT != 0 {
+		s.stats.SmoothedRTT = &smoothedRTT
+	}
+}
+
+// ReceivedSessionTicket is called before the ticket is stored in the session cache, tickets with a lifetime of
+// 0 are discarded by the TLS stack and never stored
+func (s *quicConnectionStats) ReceivedSessionTicket(lifetime time.Duration) {
+	if lifetime == 0 {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.ticketLifetimes = append(s.ticketLifetimes, lifetime)
+}
+
+// nextTicketLifetime returns the lifetime hint of the session ticket stored next
+func (s *quicConnectionStats) nextTicketLifetime() *time.Duration {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if len(s.ticketLifetimes) == 0 {
+		return nil
+	}
+	lifetime := s.ticketLifetimes[0]
+	s.ticketLifetimes = s.ticketLifetimes[1:]
+	return &lifetime
+}
+
+func (s *quicConnectionStats) StartedConnection(_, _ net.Addr, _, _ logging.ConnectionID) {}
+func (s *quicConnectionStats) NegotiatedVersion(_ logging.VersionNumber, _, _ []logging.VersionNumber) {
+}
+func (s *quicConnectionStats) ClosedConnection(error)                                   {}
+func (s *quicConnectionStats) SentTransportParameters(*logging.TransportParameters)     {}
+func (s *quicConnectionStats) ReceivedTransportParameters(*logging.TransportParameters) {}
+func (s *quicConnectionStats) RestoredTransportParameters(*logging.TransportParameters) {}
+func (s *quicConnectionStats) BufferedPacket(logging.PacketType)                        {}
+func (s *quicConnectionStats) DroppedPacket(logging.PacketType, logging.ByteCount, logging.PacketDropReason) {
+}
+func (s *quicConnectionStats) UpdatedCongestionState(logging.CongestionState)                     {}
+func (s *quicConnectionStats) UpdatedKey(logging.KeyPhase, bool)                                  {}
+func (s *quicConnectionStats) DroppedEncryptionLevel(logging.EncryptionLevel)                     {}
+func (s *quicConnectionStats) DroppedKey(logging.KeyPhase)                                        {}
+func (s *quicConnectionStats) SetLossTimer(logging.TimerType, logging.EncryptionLevel, time.Time) {}
+func (s *quicConnectionStats) LossTimerExpired(logging.TimerType, logging.EncryptionLevel)        {}
+func (s *quicConnectionStats) LossTimerCanceled()                                                 {}
+func (s *quicConnectionStats) Close()                                                             {}
+func (s *quicConnectionStats) Debug(_, _ string)                                                  {}
+
+// quicStatsTracer hands the quicConnectionStats to the connection quic-go creates for an attempt
+type quicStatsTracer struct {
+	stats *quicConnectionStats
+}
+
+func (t quicStatsTracer) TracerForConnection(context.Context, logging.Perspective, logging.ConnectionID) logging.ConnectionTracer {
+	return t.stats
+}
+
+func (t quicStatsTracer) SentPacket(net.Addr, *logging.Header, logging.ByteCount, []logging.Frame) {
+}
+
+func (t quicStatsTracer) DroppedPacket(net.Addr, logging.PacketType, logging.ByteCount, logging.PacketDropReason) {
+}
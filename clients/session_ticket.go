@@ -0,0 +1,67 @@
+package clients
+
+import (
+	"context"
+	"crypto/tls"
+	"github.com/mgranderath/dnsperf/metrics"
+	"github.com/miekg/dns"
+	"time"
+)
+
+// ticketLifetimes returns the lifetime hints of the session tickets of a connection in the order the tickets are
+// stored in the session cache, nil if the hint is not known
+type ticketLifetimes interface {
+	nextTicketLifetime() *time.Duration
+}
+
+// ticketRecorder records the session tickets a connection receives in the collector of the exchange that
+// established it and stores them in the session cache
+type ticketRecorder struct {
+	tls.ClientSessionCache
+	lifetimes ticketLifetimes
+	collector *metrics.Collector
+}
+
+func (r *ticketRecorder) Put(sessionKey string, cs *tls.ClientSessionState) {
+	// nil removes an entry that could not be used
+	if cs != nil {
+		r.collector.TLSSessionTicket(r.lifetimes.nextTicketLifetime())
+	}
+	r.ClientSessionCache.Put(sessionKey, cs)
+}
+
+// recordSessionTickets returns the TLS configuration of a new connection that records its session tickets together
+// with their lifetime hints from lifetimes
+func (c *baseClient) recordSessionTickets(tlsConfig *tls.Config, lifetimes ticketLifetimes, collector *metrics.Collector) *tls.Config {
+	if tlsConfig.ClientSessionCache == nil {
+		return tlsConfig
+	}
+
+	tlsConfig = tlsConfig.Clone()
+	tlsConfig.ClientSessionCache = &ticketRecorder{ClientSessionCache: tlsConfig.ClientSessionCache, lifetimes: lifetimes, collector: collector}
+	return tlsConfig
+}
+
+// PrimeAndResume sends m twice to the upstream over two connections, the first exchange receives a session ticket
+// which the second one resumes the TLS session with, so that the results compare a full and a resumed handshake.
+// A session cache is created if TLSOptions.ClientSessionCache is not set, Options.ReuseConnection is ignored.
+func PrimeAndResume(ctx context.Context, address string, options Options, m *dns.Msg) (prime *metrics.WithResponseOrError, resumed *metrics.WithResponseOrError, err error) {
+	tlsOptions := TLSOptions{}
+	if options.TLSOptions != nil {
+		tlsOptions = *options.TLSOptions
+	}
+	if tlsOptions.ClientSessionCache == nil {
+		tlsOptions.ClientSessionCache = tls.NewLRUClientSessionCache(1)
+	}
+	options.TLSOptions = &tlsOptions
+	options.ReuseConnection = false
+
+	client, err := AddressToClient(address, options)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	prime = client.ExchangeContext(ctx, m.Copy())
+	resumed = client.ExchangeContext(ctx, m.Copy())
+	return prime, resumed, nil
+}
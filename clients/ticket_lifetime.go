@@ -0,0 +1,318 @@
+package clients
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/cryptobyte"
+	"golang.org/x/crypto/hkdf"
+	"hash"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	recordTypeChangeCipherSpec = 20
+	recordTypeApplicationData  = 23
+
+	handshakeTypeServerHello      = 2
+	handshakeTypeNewSessionTicket = 4
+
+	extensionSupportedVersions = 43
+
+	// maxTLSRecordLength is the largest length of a TLS 1.3 record with its expansion by the AEAD
+	maxTLSRecordLength = 1<<14 + 256
+)
+
+// tlsTicketReader reads the lifetime hints of the session tickets a TLS connection receives from its records,
+// crypto/tls does not export them. TLS 1.2 servers send the NewSessionTicket message in plaintext before their
+// ChangeCipherSpec, TLS 1.3 servers encrypt it with the application traffic secret, which crypto/tls hands to the
+// KeyLogWriter. Servers send their tickets right after the handshake, so records are only inspected until the
+// first application data or a record that cannot be read.
+type tlsTicketReader struct {
+	net.Conn
+
+	mutex   sync.Mutex
+	done    bool
+	keyLog  io.Writer
+	records []byte
+
+	// the handshake messages of the records inspected so far that were not complete yet
+	handshake []byte
+	tls13     bool
+	suite     uint16
+	encrypted bool
+
+	// pending are the encrypted records received before the application traffic secret is known, aead and seq
+	// decrypt them once it is, opened is set once the first record was decrypted with it
+	pending [][]byte
+	aead    cipher.AEAD
+	iv      []byte
+	seq     uint64
+	opened  bool
+
+	lifetimes []*time.Duration
+}
+
+var _ ticketLifetimes = &tlsTicketReader{}
+
+// config returns tlsConfig with the KeyLogWriter that receives the traffic secrets, the secrets are only asked
+// for if tickets are stored in a session cache
+func (r *tlsTicketReader) config(tlsConfig *tls.Config) *tls.Config {
+	if tlsConfig.ClientSessionCache == nil {
+		r.done = true
+		return tlsConfig
+	}
+
+	tlsConfig = tlsConfig.Clone()
+	r.keyLog = tlsConfig.KeyLogWriter
+	tlsConfig.KeyLogWriter = &tlsTicketKeyLog{reader: r}
+	return tlsConfig
+}
+
+func (r *tlsTicketReader) Read(p []byte) (int, error) {
+	n, err := r.Conn.Read(p)
+	if n != 0 {
+		r.mutex.Lock()
+		r.received(p[:n])
+		r.mutex.Unlock()
+	}
+	return n, err
+}
+
+// nextTicketLifetime is called by crypto/tls once it read the record with the ticket through r
+func (r *tlsTicketReader) nextTicketLifetime() *time.Duration {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.openPending()
+	if len(r.lifetimes) == 0 {
+		return nil
+	}
+	lifetime := r.lifetimes[0]
+	r.lifetimes = r.lifetimes[1:]
+	return lifetime
+}
+
+// received splits the bytes read from the connection into records
+func (r *tlsTicketReader) received(p []byte) {
+	if r.done {
+		return
+	}
+	r.records = append(r.records, p...)
+	for !r.done && len(r.records) >= 5 {
+		length := int(binary.BigEndian.Uint16(r.records[3:5]))
+		if length > maxTLSRecordLength {
+			r.stop()
+			return
+		}
+		if len(r.records) < 5+length {
+			return
+		}
+		record := r.records[:5+length]
+		r.records = r.records[5+length:]
+		r.record(record)
+	}
+}
+
+func (r *tlsTicketReader) record(record []byte) {
+	switch {
+	case record[0] == recordTypeHandshake && !r.encrypted:
+		r.handshakeMessages(record[5:])
+	case record[0] == recordTypeChangeCipherSpec:
+		// TLS 1.2 encrypts the remaining handshake messages, TLS 1.3 sends it for middlebox compatibility only
+		r.encrypted = true
+		r.handshake = nil
+		if !r.tls13 {
+			r.stop()
+		}
+	case record[0] == recordTypeApplicationData && r.tls13:
+		r.pending = append(r.pending, append([]byte(nil), record...))
+		r.openPending()
+	default:
+		r.stop()
+	}
+}
+
+// handshakeMessages parses the handshake messages in the content of a record, messages may span records
+func (r *tlsTicketReader) handshakeMessages(content []byte) {
+	r.handshake = append(r.handshake, content...)
+	for !r.done && len(r.handshake) >= 4 {
+		length := int(r.handshake[1])<<16 | int(r.handshake[2])<<8 | int(r.handshake[3])
+		if len(r.handshake) < 4+length {
+			return
+		}
+		msgType, body := r.handshake[0], r.handshake[4:4+length]
+		r.handshake = r.handshake[4+length:]
+
+		switch msgType {
+		case handshakeTypeServerHello:
+			r.serverHello(body)
+		case handshakeTypeNewSessionTicket:
+			r.newSessionTicket(body)
+		}
+	}
+}
+
+// serverHello reads the negotiated version and cipher suite, a HelloRetryRequest carries the same ones
+func (r *tlsTicketReader) serverHello(body []byte) {
+	s := cryptobyte.String(body)
+	var sessionID, extensions cryptobyte.String
+	var compression uint8
+	if !s.Skip(2+32) || !s.ReadUint8LengthPrefixed(&sessionID) || !s.ReadUint16(&r.suite) ||
+		!s.ReadUint8(&compression) {
+		r.stop()
+		return
+	}
+	if !s.ReadUint16LengthPrefixed(&extensions) {
+		return
+	}
+	for !extensions.Empty() {
+		var extension uint16
+		var data cryptobyte.String
+		if !extensions.ReadUint16(&extension) || !extensions.ReadUint16LengthPrefixed(&data) {
+			r.stop()
+			return
+		}
+		var version uint16
+		if extension == extensionSupportedVersions && data.ReadUint16(&version) {
+			r.tls13 = version == tls.VersionTLS13
+		}
+	}
+}
+
+// newSessionTicket queues the lifetime hint of a ticket, TLS 1.2 tickets with a hint of 0 have an unspecified
+// lifetime, TLS 1.3 tickets with a lifetime of 0 are discarded by crypto/tls
+func (r *tlsTicketReader) newSessionTicket(body []byte) {
+	if len(body) < 4 {
+		r.stop()
+		return
+	}
+	lifetime := time.Duration(binary.BigEndian.Uint32(body)) * time.Second
+	switch {
+	case lifetime != 0:
+		r.lifetimes = append(r.lifetimes, &lifetime)
+	case !r.tls13:
+		r.lifetimes = append(r.lifetimes, nil)
+	}
+}
+
+// trafficSecret derives the key of the server application traffic secret
+func (r *tlsTicketReader) trafficSecret(secret []byte) {
+	var newHash func() hash.Hash
+	var keyLength int
+	var aeadFor func(key []byte) (cipher.AEAD, error)
+	switch r.suite {
+	case tls.TLS_AES_128_GCM_SHA256:
+		newHash, keyLength, aeadFor = sha256.New, 16, newGCM
+	case tls.TLS_AES_256_GCM_SHA384:
+		newHash, keyLength, aeadFor = sha512.New384, 32, newGCM
+	case tls.TLS_CHACHA20_POLY1305_SHA256:
+		newHash, keyLength, aeadFor = sha256.New, chacha20poly1305.KeySize, chacha20poly1305.New
+	default:
+		r.stop()
+		return
+	}
+
+	aead, err := aeadFor(expandLabel(newHash, secret, "key", keyLength))
+	if err != nil {
+		r.stop()
+		return
+	}
+	r.aead = aead
+	r.iv = expandLabel(newHash, secret, "iv", aead.NonceSize())
+	r.openPending()
+}
+
+// openPending decrypts the pending records, the records before the first one that can be opened are protected by
+// the handshake traffic secret
+func (r *tlsTicketReader) openPending() {
+	for !r.done && r.aead != nil && len(r.pending) != 0 {
+		record := r.pending[0]
+		r.pending = r.pending[1:]
+
+		nonce := make([]byte, len(r.iv))
+		copy(nonce, r.iv)
+		for i := 0; i < 8; i++ {
+			nonce[len(nonce)-1-i] ^= byte(r.seq >> (8 * i))
+		}
+		plaintext, err := r.aead.Open(nil, nonce, record[5:], record[:5])
+		if err != nil {
+			if r.opened {
+				r.stop()
+			}
+			continue
+		}
+		r.opened = true
+		r.seq++
+
+		// the content is followed by its type and optional zero padding
+		plaintext = bytes.TrimRight(plaintext, "\x00")
+		if len(plaintext) == 0 || plaintext[len(plaintext)-1] != recordTypeHandshake {
+			r.stop()
+			return
+		}
+		r.handshakeMessages(plaintext[:len(plaintext)-1])
+	}
+}
+
+// stop ends inspecting the records, the lifetimes read so far are kept
+func (r *tlsTicketReader) stop() {
+	r.done = true
+	r.records, r.handshake, r.pending = nil, nil, nil
+}
+
+// tlsTicketKeyLog passes the server application traffic secret to the tlsTicketReader and the key log on to the
+// KeyLogWriter of the configuration
+type tlsTicketKeyLog struct {
+	reader *tlsTicketReader
+}
+
+func (l *tlsTicketKeyLog) Write(p []byte) (int, error) {
+	r := l.reader
+	fields := strings.Fields(string(p))
+	if len(fields) == 3 && fields[0] == "SERVER_TRAFFIC_SECRET_0" {
+		if secret, err := hex.DecodeString(fields[2]); err == nil {
+			r.mutex.Lock()
+			if !r.done && r.tls13 {
+				r.trafficSecret(secret)
+			}
+			r.mutex.Unlock()
+		}
+	}
+
+	if r.keyLog != nil {
+		return r.keyLog.Write(p)
+	}
+	return len(p), nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// expandLabel is HKDF-Expand-Label of RFC 8446 with an empty context
+func expandLabel(newHash func() hash.Hash, secret []byte, label string, length int) []byte {
+	var info cryptobyte.Builder
+	info.AddUint16(uint16(length))
+	info.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddBytes([]byte("tls13 " + label))
+	})
+	info.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) {})
+
+	out := make([]byte, length)
+	_, _ = io.ReadFull(hkdf.Expand(newHash, secret, info.BytesOrPanic()), out)
+	return out
+}
@@ -0,0 +1,151 @@
+package clients
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+// lifetimeCache stores the lifetime hints the tlsTicketReader returns for the tickets crypto/tls stores
+type lifetimeCache struct {
+	tls.ClientSessionCache
+	reader    *tlsTicketReader
+	lifetimes []*time.Duration
+}
+
+func (c *lifetimeCache) Put(sessionKey string, cs *tls.ClientSessionState) {
+	if cs != nil {
+		c.lifetimes = append(c.lifetimes, c.reader.nextTicketLifetime())
+	}
+	c.ClientSessionCache.Put(sessionKey, cs)
+}
+
+func newTestServerCertificate(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := newTestCertificate(t, &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "dns.example"},
+		DNSNames:     []string{"dns.example"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, nil, key, key)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return tls.Certificate{Certificate: [][]byte{cert.Raw}, PrivateKey: key}, pool
+}
+
+func TestTLSTicketReaderHandshake(t *testing.T) {
+	certificate, roots := newTestServerCertificate(t)
+	sevenDays := 7 * 24 * time.Hour
+
+	tests := []struct {
+		name    string
+		version uint16
+		want    *time.Duration
+	}{
+		// crypto/tls issues TLS 1.3 tickets for 7 days and leaves the TLS 1.2 hint unspecified
+		{"tls 1.3", tls.VersionTLS13, &sevenDays},
+		{"tls 1.2", tls.VersionTLS12, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientConn, serverConn := net.Pipe()
+			defer clientConn.Close()
+			defer serverConn.Close()
+
+			go func() {
+				server := tls.Server(serverConn, &tls.Config{Certificates: []tls.Certificate{certificate}, MaxVersion: tt.version})
+				if server.Handshake() == nil {
+					_, _ = server.Write([]byte("ok"))
+				}
+			}()
+
+			reader := &tlsTicketReader{Conn: clientConn}
+			cache := &lifetimeCache{ClientSessionCache: tls.NewLRUClientSessionCache(1), reader: reader}
+			config := reader.config(&tls.Config{RootCAs: roots, ServerName: "dns.example", ClientSessionCache: cache})
+			client := tls.Client(reader, config)
+			// TLS 1.3 tickets are handled when the application data after them is read
+			if _, err := client.Read(make([]byte, 2)); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(cache.lifetimes) != 1 {
+				t.Fatalf("lifetimes = %v, want 1 ticket", cache.lifetimes)
+			}
+			if got := cache.lifetimes[0]; (got == nil) != (tt.want == nil) || got != nil && *got != *tt.want {
+				t.Errorf("nextTicketLifetime() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// tls12Record returns a TLS 1.2 record with content
+func tls12Record(recordType byte, content []byte) []byte {
+	return append([]byte{recordType, 3, 3, byte(len(content) >> 8), byte(len(content))}, content...)
+}
+
+func TestTLSTicketReaderTLS12(t *testing.T) {
+	// a ServerHello without extensions and NewSessionTicket messages with a lifetime hint of 3600 and 0 seconds
+	serverHello := append([]byte{handshakeTypeServerHello, 0, 0, 38, 3, 3}, make([]byte, 32)...)
+	serverHello = append(serverHello, 0, 0x00, 0x2f, 0)
+	ticket := []byte{handshakeTypeNewSessionTicket, 0, 0, 9, 0, 0, 0x0e, 0x10, 0, 3, 1, 2, 3}
+	unspecified := []byte{handshakeTypeNewSessionTicket, 0, 0, 9, 0, 0, 0, 0, 0, 3, 1, 2, 3}
+	changeCipherSpec := tls12Record(recordTypeChangeCipherSpec, []byte{1})
+	hour := time.Hour
+
+	tests := []struct {
+		name    string
+		records [][]byte
+		want    []*time.Duration
+	}{
+		{"hint", [][]byte{tls12Record(recordTypeHandshake, append(serverHello, ticket...)), changeCipherSpec}, []*time.Duration{&hour}},
+		{"unspecified", [][]byte{tls12Record(recordTypeHandshake, append(serverHello, unspecified...)), changeCipherSpec}, []*time.Duration{nil}},
+		{"split across records", [][]byte{
+			tls12Record(recordTypeHandshake, append(serverHello, ticket[:6]...)),
+			tls12Record(recordTypeHandshake, ticket[6:]),
+			changeCipherSpec,
+		}, []*time.Duration{&hour}},
+		{"after change cipher spec", [][]byte{
+			tls12Record(recordTypeHandshake, serverHello),
+			changeCipherSpec,
+			tls12Record(recordTypeHandshake, ticket),
+		}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &tlsTicketReader{}
+			for _, record := range tt.records {
+				// the connection returns records in arbitrary chunks
+				for i := range record {
+					r.received(record[i : i+1])
+				}
+			}
+
+			var got []*time.Duration
+			for len(r.lifetimes) != 0 {
+				got = append(got, r.nextTicketLifetime())
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("lifetimes = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if (got[i] == nil) != (tt.want[i] == nil) || got[i] != nil && *got[i] != *tt.want[i] {
+					t.Errorf("nextTicketLifetime() = %v, want %v", got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package clients
+
+import (
+	"errors"
+	"github.com/mgranderath/dnsperf/terr"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// tlsAlertCodes maps the text of the alerts of crypto/tls to their codes
+var tlsAlertCodes = map[string]terr.ErrorCode{
+	"close notify":                    0,
+	"unexpected message":              terr.UnexpectedMessage,
+	"bad record MAC":                  terr.BadRecordMac,
+	"decryption failed":               terr.DecryptionFailed,
+	"record overflow":                 terr.RecordOverflow,
+	"decompression failure":           terr.DecompressionFail,
+	"handshake failure":               terr.HandshakeFailure,
+	"bad certificate":                 terr.BadCertificate,
+	"unsupported certificate":         terr.UnsupportedCert,
+	"revoked certificate":             terr.CertificateRevoked,
+	"expired certificate":             terr.CertificateExpired,
+	"unknown certificate":             terr.CertificateUnknown,
+	"illegal parameter":               47,
+	"unknown certificate authority":   terr.UnknownCA,
+	"access denied":                   terr.AccessDenied,
+	"error decoding message":          terr.DecodeError,
+	"error decrypting message":        terr.DecryptError,
+	"export restriction":              terr.ExportRestriction,
+	"protocol version not supported":  terr.ProtocolVersion,
+	"insufficient security level":     terr.InsufficientSecurity,
+	"internal error":                  terr.InternalError,
+	"inappropriate fallback":          86,
+	"user canceled":                   terr.UserCancelled,
+	"no renegotiation":                terr.NoRenogiation,
+	"missing extension":               109,
+	"unsupported extension":           terr.UnsupportedExt,
+	"certificate unobtainable":        111,
+	"unrecognized name":               112,
+	"bad certificate status response": 113,
+	"bad certificate hash value":      114,
+	"unknown PSK identity":            115,
+	"certificate required":            116,
+	"no application protocol":         120,
+}
+
+// tlsAlert returns the code of the alert in err, crypto/tls does not export its alert type but wraps alerts it
+// receives in a *net.OpError with the Op "remote error" and alerts it sends with the Op "local error"
+func tlsAlert(err error) (terr.ErrorCode, bool) {
+	var opErr *net.OpError
+	if !errors.As(err, &opErr) || opErr.Op != "remote error" && opErr.Op != "local error" || opErr.Err == nil {
+		return 0, false
+	}
+
+	text := opErr.Err.Error()
+	if !strings.HasPrefix(text, "tls: ") {
+		return 0, false
+	}
+	text = strings.TrimPrefix(text, "tls: ")
+	if code, ok := tlsAlertCodes[text]; ok {
+		return code, true
+	}
+	// alerts without a text are written as alert(code)
+	if strings.HasPrefix(text, "alert(") && strings.HasSuffix(text, ")") {
+		code, err := strconv.ParseUint(text[len("alert("):len(text)-1], 10, 8)
+		if err == nil {
+			return terr.ErrorCode(code), true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,71 @@
+package clients
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/mgranderath/dnsperf/terr"
+	"net"
+	"testing"
+)
+
+// handshakeErrors returns the errors of the client and the server handshaking with each other
+func handshakeErrors(clientConfig, serverConfig *tls.Config) (error, error) {
+	// both sides may write an alert at the same time, which blocks on a net.Pipe
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err, err
+	}
+	defer listener.Close()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		serverConn, err := listener.Accept()
+		if err != nil {
+			serverErr <- err
+			return
+		}
+		defer serverConn.Close()
+		serverErr <- tls.Server(serverConn, serverConfig).Handshake()
+	}()
+	clientConn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		return err, err
+	}
+	err = tls.Client(clientConn, clientConfig).Handshake()
+	clientConn.Close()
+	return err, <-serverErr
+}
+
+func TestTLSAlert(t *testing.T) {
+	certificate, roots := newTestServerCertificate(t)
+	serverConfig := &tls.Config{Certificates: []tls.Certificate{certificate}, MinVersion: tls.VersionTLS13}
+
+	// the server rejects the version of the client, the client rejects the name of the certificate
+	versionErr, _ := handshakeErrors(&tls.Config{RootCAs: roots, ServerName: "dns.example", MaxVersion: tls.VersionTLS12}, serverConfig)
+	_, nameErr := handshakeErrors(&tls.Config{RootCAs: roots, ServerName: "other.example"}, serverConfig)
+
+	tests := []struct {
+		name   string
+		err    error
+		want   terr.ErrorCode
+		wantOk bool
+	}{
+		{"remote alert", versionErr, terr.ProtocolVersion, true},
+		{"remote alert of the client", nameErr, terr.BadCertificate, true},
+		{"wrapped", fmt.Errorf("exchange: %w", versionErr), terr.ProtocolVersion, true},
+		{"local alert", &net.OpError{Op: "local error", Err: errors.New("tls: unknown certificate authority")}, terr.UnknownCA, true},
+		{"alert without text", &net.OpError{Op: "remote error", Err: errors.New("tls: alert(200)")}, 200, true},
+		{"dial error", &net.OpError{Op: "dial", Err: errors.New("tls: bad certificate")}, 0, false},
+		{"other error", errors.New("tls: bad certificate"), 0, false},
+		{"nil", nil, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := tlsAlert(tt.err)
+			if got != tt.want || ok != tt.wantOk {
+				t.Errorf("tlsAlert(%v) = %v, %v, want %v, %v", tt.err, got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
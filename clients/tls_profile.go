@@ -0,0 +1,271 @@
+package clients
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/mgranderath/dnsperf/metrics"
+	"github.com/miekg/dns"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// UsageProfile is a DNS privacy usage profile of RFC 8310 section 5
+type UsageProfile int
+
+const (
+	// ProfileNone verifies certificates as selected by TLSOptions.InsecureSkipVerify and TLSOptions.SkipCommonName,
+	// or like ProfileStrict if TLSOptions.DANEResolver is set
+	ProfileNone UsageProfile = iota
+
+	// ProfileStrict requires the server to be authenticated, connections to servers that fail authentication are
+	// aborted. The server is authenticated with the SPKI pins if there are any, and against the authentication
+	// domain name if there are no pins or it was given explicitly.
+	ProfileStrict
+
+	// ProfileOpportunistic authenticates the server like ProfileStrict but uses the connection even if that failed,
+	// authentication is skipped if neither SPKI pins nor an authentication domain name were given
+	ProfileOpportunistic
+)
+
+func (p UsageProfile) String() string {
+	switch p {
+	case ProfileStrict:
+		return "strict"
+	case ProfileOpportunistic:
+		return "opportunistic"
+	default:
+		return "none"
+	}
+}
+
+// ErrSPKIPinMismatch is returned when no certificate of the chain matches the SPKI pins
+var ErrSPKIPinMismatch = errors.New("no certificate matches the SPKI pins")
+
+// authenticator authenticates servers according to the usage profile, the results of handshakes are kept per
+// certificate chain so that exchanges on reused connections report them without verifying the chain again
+type authenticator struct {
+	profile    UsageProfile
+	domainName string
+	pins       [][sha256.Size]byte
+	checkName  bool
+
+	// configured is set if SPKI pins, an authentication domain name or DANE were given
+	configured bool
+
+	// dane replaces the SPKI pins and RootCAs if TLSOptions.DANEResolver is set
+	dane *daneVerifier
+
+	mutex   sync.Mutex
+	results map[[sha256.Size]byte]error
+}
+
+// newAuthenticator returns nil if TLSOptions select neither a usage profile nor DANE or the upstream does not use TLS
+func newAuthenticator(options *TLSOptions, upsURL *url.URL) (*authenticator, error) {
+	if options == nil || options.Profile == ProfileNone && options.DANEResolver == nil {
+		return nil, nil
+	}
+
+	var transport string
+	switch upsURL.Scheme {
+	case "tls", "https":
+		transport = "tcp"
+	case "quic", "h3":
+		transport = "udp"
+	default:
+		return nil, nil
+	}
+
+	a := &authenticator{
+		profile:    options.Profile,
+		domainName: upsURL.Hostname(),
+		checkName:  len(options.SPKIPins) == 0 || options.AuthDomainName != "",
+		configured: len(options.SPKIPins) != 0 || options.AuthDomainName != "" || options.DANEResolver != nil,
+		results:    map[[sha256.Size]byte]error{},
+	}
+	if options.AuthDomainName != "" {
+		a.domainName = options.AuthDomainName
+	}
+	for _, pin := range options.SPKIPins {
+		decoded, err := base64.StdEncoding.DecodeString(pin)
+		if err != nil || len(decoded) != sha256.Size {
+			return nil, fmt.Errorf("SPKI pin %q is not a base64 encoded SHA-256 hash", pin)
+		}
+		var hash [sha256.Size]byte
+		copy(hash[:], decoded)
+		a.pins = append(a.pins, hash)
+	}
+
+	if options.DANEResolver != nil {
+		if a.profile == ProfileNone {
+			a.profile = ProfileStrict
+		}
+		var err error
+		a.dane, err = newDANEVerifier(options.DANEResolver, a.domainName, upsURL.Port(), transport)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+// skipped reports whether the opportunistic profile has nothing to authenticate the server with
+func (a *authenticator) skipped() bool {
+	return a.profile == ProfileOpportunistic && !a.configured
+}
+
+// authenticate verifies the certificate chain sent by the server during a handshake, it returns the TLSA record
+// that matched the chain if DANE is used
+func (a *authenticator) authenticate(certs []*x509.Certificate) (*dns.TLSA, error) {
+	tlsa, err := a.verify(certs)
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.results[chainHash(certs)] = err
+	return tlsa, err
+}
+
+// authenticated returns the result of the handshake that established a reused connection
+func (a *authenticator) authenticated(certs []*x509.Certificate) error {
+	a.mutex.Lock()
+	err, ok := a.results[chainHash(certs)]
+	a.mutex.Unlock()
+	if ok {
+		return err
+	}
+	_, err = a.authenticate(certs)
+	return err
+}
+
+func (a *authenticator) verify(certs []*x509.Certificate) (*dns.TLSA, error) {
+	if len(certs) == 0 {
+		return nil, errors.New("server sent no certificate")
+	}
+	if a.dane != nil {
+		return a.dane.verify(certs, a.domainName)
+	}
+	if len(a.pins) != 0 && !matchesSPKIPins(certs, a.pins) {
+		return nil, ErrSPKIPinMismatch
+	}
+	if !a.checkName {
+		return nil, nil
+	}
+	_, err := verifyPKIX(certs, a.domainName)
+	return nil, err
+}
+
+// verifyPKIX verifies the chain against RootCAs and domainName
+func verifyPKIX(certs []*x509.Certificate, domainName string) ([][]*x509.Certificate, error) {
+	return verifyChain(certs, domainName, RootCAs)
+}
+
+func verifyChain(certs []*x509.Certificate, domainName string, roots *x509.CertPool) ([][]*x509.Certificate, error) {
+	opts := x509.VerifyOptions{
+		Roots:         roots,
+		CurrentTime:   time.Now(),
+		DNSName:       domainName,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range certs[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	return certs[0].Verify(opts)
+}
+
+func authenticationStatus(err error) metrics.TLSAuthentication {
+	if err != nil {
+		return metrics.TLSAuthenticationFailed
+	}
+	return metrics.TLSAuthenticationSucceeded
+}
+
+// matchesSPKIPins reports whether any certificate of the chain matches a pin (RFC 7858 section 4.2)
+func matchesSPKIPins(certs []*x509.Certificate, pins [][sha256.Size]byte) bool {
+	for _, cert := range certs {
+		hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+		for _, pin := range pins {
+			if bytes.Equal(hash[:], pin[:]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func chainHash(certs []*x509.Certificate) [sha256.Size]byte {
+	h := sha256.New()
+	for _, cert := range certs {
+		h.Write(cert.Raw)
+	}
+	var sum [sha256.Size]byte
+	copy(sum[:], h.Sum(nil))
+	return sum
+}
+
+// getConnectionTLSConfig returns the TLS configuration of a new connection, it records when the certificate was
+// verified to the handshake timeline and, with a usage profile, authenticates the server during the handshake and
+// records the result by collector
+func (c *baseClient) getConnectionTLSConfig(tlsConfig *tls.Config, collector *metrics.Collector) *tls.Config {
+	tlsConfig = tlsConfig.Clone()
+	tlsConfig.VerifyConnection = func(state tls.ConnectionState) error {
+		// crypto/tls calls VerifyConnection once it verified the certificate chain
+		if err := c.authenticate(state, collector); err != nil {
+			return err
+		}
+		collector.HandshakeEvent(metrics.HandshakeEventCertificateVerified)
+		return nil
+	}
+	return tlsConfig
+}
+
+// authenticate authenticates the server of state with the usage profile, only the strict profile fails the
+// handshake
+func (c *baseClient) authenticate(state tls.ConnectionState, collector *metrics.Collector) error {
+	a := c.authenticator
+	if a == nil {
+		return nil
+	}
+	if a.skipped() {
+		collector.TLSAuthentication(a.profile.String(), metrics.TLSAuthenticationSkipped)
+		return nil
+	}
+	tlsa, err := a.authenticate(state.PeerCertificates)
+	collector.TLSAuthentication(a.profile.String(), authenticationStatus(err))
+	if a.dane != nil {
+		collector.DANE(daneResult(err), tlsaRecordString(tlsa))
+	}
+	if a.profile == ProfileStrict {
+		return err
+	}
+	return nil
+}
+
+// lookupTLSA looks up the TLSA records before a connection is established, so that the lookup is not part of the
+// handshake and its time recorded by collector on its own
+func (c *baseClient) lookupTLSA(ctx context.Context, collector *metrics.Collector) {
+	if c.authenticator != nil && c.authenticator.dane != nil {
+		c.authenticator.dane.lookup(ctx, collector)
+	}
+}
+
+// recordTLSState records an established TLS connection, including the authentication result of the usage profile
+// for connections that were reused from an earlier exchange
+func (c *baseClient) recordTLSState(state tls.ConnectionState, collector *metrics.Collector) {
+	collector.TLSConnectionState(state)
+
+	a := c.authenticator
+	if a == nil {
+		return
+	}
+	if a.skipped() {
+		collector.TLSAuthentication(a.profile.String(), metrics.TLSAuthenticationSkipped)
+		return
+	}
+	collector.TLSAuthentication(a.profile.String(), authenticationStatus(a.authenticated(state.PeerCertificates)))
+}
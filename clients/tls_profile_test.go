@@ -0,0 +1,103 @@
+package clients
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"testing"
+)
+
+func spkiPin(cert *x509.Certificate) string {
+	hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+func TestMatchesSPKIPins(t *testing.T) {
+	chain := newTestChain(t)
+	other := newTestChain(t)
+
+	tests := []struct {
+		name string
+		pins []*x509.Certificate
+		want bool
+	}{
+		{"leaf", []*x509.Certificate{chain.leaf}, true},
+		{"root", []*x509.Certificate{chain.root}, true},
+		{"backup pin", []*x509.Certificate{other.leaf, chain.leaf}, true},
+		{"other chain", []*x509.Certificate{other.leaf, other.root}, false},
+		{"no pins", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var pins [][sha256.Size]byte
+			for _, cert := range tt.pins {
+				pins = append(pins, sha256.Sum256(cert.RawSubjectPublicKeyInfo))
+			}
+			if got := matchesSPKIPins(chain.certs(), pins); got != tt.want {
+				t.Errorf("matchesSPKIPins() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthenticatorSPKIPins(t *testing.T) {
+	chain := newTestChain(t)
+	other := newTestChain(t)
+
+	tests := []struct {
+		name           string
+		pins           []string
+		authDomainName string
+		trusted        bool
+		wantErr        bool
+	}{
+		// pins alone authenticate the server without checking its name or issuer
+		{"pin matches", []string{spkiPin(chain.leaf)}, "", false, false},
+		{"root pin matches", []string{spkiPin(chain.root)}, "", false, false},
+		{"pin mismatch", []string{spkiPin(other.leaf)}, "", true, true},
+		// an explicit authentication domain name is checked in addition to the pins
+		{"pin and name match", []string{spkiPin(chain.leaf)}, "dns.example", true, false},
+		{"pin matches untrusted name", []string{spkiPin(chain.leaf)}, "dns.example", false, true},
+		{"pin matches wrong name", []string{spkiPin(chain.leaf)}, "other.example", true, true},
+		{"pin mismatch trusted name", []string{spkiPin(other.leaf)}, "dns.example", true, true},
+	}
+	defer func(roots *x509.CertPool) { RootCAs = roots }(RootCAs)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			RootCAs = x509.NewCertPool()
+			if tt.trusted {
+				RootCAs = chain.roots()
+			}
+			a, err := newAuthenticator(&TLSOptions{
+				Profile:        ProfileStrict,
+				SPKIPins:       tt.pins,
+				AuthDomainName: tt.authDomainName,
+			}, mustParseURL(t, "tls://192.0.2.1:853"))
+			if err != nil {
+				t.Fatalf("newAuthenticator() error = %v", err)
+			}
+			if _, err := a.authenticate(chain.certs()); (err != nil) != tt.wantErr {
+				t.Errorf("authenticate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewAuthenticatorInvalidPin(t *testing.T) {
+	tests := []struct {
+		name string
+		pin  string
+	}{
+		{"not base64", "not a pin!"},
+		{"too short", base64.StdEncoding.EncodeToString(make([]byte, sha256.Size-1))},
+		{"too long", base64.StdEncoding.EncodeToString(make([]byte, sha256.Size+1))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newAuthenticator(&TLSOptions{Profile: ProfileStrict, SPKIPins: []string{tt.pin}}, mustParseURL(t, "tls://192.0.2.1:853"))
+			if err == nil {
+				t.Error("newAuthenticator() error = nil, want an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,555 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/lucas-clemente/quic-go"
+	"github.com/mgranderath/dnsperf/clients"
+	"github.com/mgranderath/dnsperf/exporter"
+	"github.com/mgranderath/dnsperf/loadgen"
+	"github.com/mgranderath/dnsperf/metrics"
+	"github.com/mgranderath/dnsperf/queries"
+	"github.com/miekg/dns"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Exit codes
+const (
+	exitOK      = 0
+	exitFailure = 1
+	exitUsage   = 2
+)
+
+// stringList is a flag that can be repeated or given as a comma separated list
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*l = append(*l, v)
+		}
+	}
+	return nil
+}
+
+type config struct {
+	upstreams stringList
+	bootstrap stringList
+
+	name      string
+	qtype     string
+	class     string
+	dnssec    bool
+	queryFile string
+
+	count       int64
+	interval    time.Duration
+	concurrency int
+	timeout     time.Duration
+	reuse       bool
+	perAddress  bool
+	padding     string
+	tcpFallback bool
+	dnscryptTCP bool
+	dial        string
+
+	tlsMin     string
+	tlsMax     string
+	verify     string
+	spkiPins   stringList
+	authName   string
+	daneServer string
+	resumption bool
+
+	doqALPN      stringList
+	quicVersions stringList
+	zeroRTT      bool
+	localPort    int
+	qlogDir      string
+	qlogFormat   string
+
+	output        string
+	metricsListen string
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdout io.Writer, stderr io.Writer) int {
+	cfg := &config{}
+	flags := flag.NewFlagSet("dnsperf", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: dnsperf [flags] [upstream ...]\n\n")
+		fmt.Fprintf(stderr, "Upstreams are URLs such as udp://9.9.9.9, tcp://9.9.9.9, tls://dns.quad9.net,\n")
+		fmt.Fprintf(stderr, "https://dns.quad9.net/dns-query, h3://dns.adguard.com/dns-query, quic://dns.adguard.com:853\n")
+		fmt.Fprintf(stderr, "or DNSCrypt stamps (sdns://...).\n\n")
+		flags.PrintDefaults()
+	}
+
+	flags.Var(&cfg.upstreams, "upstream", "upstream URL, can be repeated or comma separated")
+	flags.Var(&cfg.bootstrap, "bootstrap", "upstream URL resolving the upstream hosts instead of the system resolver, can be repeated or comma separated")
+	flags.StringVar(&cfg.name, "name", "example.com", "query name")
+	flags.StringVar(&cfg.qtype, "type", "A", "query type")
+	flags.StringVar(&cfg.class, "class", "IN", "query class")
+	flags.BoolVar(&cfg.dnssec, "dnssec", false, "set the DO bit")
+	flags.StringVar(&cfg.queryFile, "queries", "", "read queries from a file in dnsperf format instead of -name/-type/-class")
+	flags.Int64Var(&cfg.count, "count", 3, "number of queries per upstream")
+	flags.DurationVar(&cfg.interval, "interval", time.Second, "interval between queries, 0 sends as fast as possible")
+	flags.IntVar(&cfg.concurrency, "concurrency", 1, "number of queries in flight per upstream")
+	flags.DurationVar(&cfg.timeout, "timeout", 10*time.Second, "timeout of a single query")
+	flags.BoolVar(&cfg.reuse, "reuse", false, "keep connections open between queries")
+	flags.BoolVar(&cfg.perAddress, "per-address", false, "measure every address an upstream resolves to on its own")
+	flags.StringVar(&cfg.padding, "padding", "none", "EDNS(0) padding of encrypted queries: none, block or random")
+	flags.BoolVar(&cfg.tcpFallback, "tcp-fallback", false, "retry truncated UDP responses over TCP")
+	flags.BoolVar(&cfg.dnscryptTCP, "dnscrypt-tcp", false, "send DNSCrypt queries over TCP instead of UDP")
+	flags.StringVar(&cfg.dial, "dial", "sequential", "how resolved addresses are dialed: sequential, happy-eyeballs, ipv4, ipv6 or prefer-ipv6")
+	flags.StringVar(&cfg.tlsMin, "tls-min", "1.2", "minimum TLS version (1.0, 1.1, 1.2, 1.3)")
+	flags.StringVar(&cfg.tlsMax, "tls-max", "1.3", "maximum TLS version (1.0, 1.1, 1.2, 1.3)")
+	flags.StringVar(&cfg.verify, "verify", "full", "certificate verification: full, skip-hostname, none, the RFC 8310 profiles strict and opportunistic or dane")
+	flags.Var(&cfg.spkiPins, "spki-pin", "base64 SHA-256 SPKI pin authenticating the server with -verify strict or opportunistic, can be repeated or comma separated")
+	flags.StringVar(&cfg.daneServer, "dane-resolver", "", "DNSSEC validating upstream URL the TLSA records are looked up with, required by -verify dane")
+	flags.StringVar(&cfg.authName, "auth-name", "", "authentication domain name for -verify strict or opportunistic (default the upstream host)")
+	flags.BoolVar(&cfg.resumption, "session-resumption", false, "resume TLS sessions across connections (QUIC resumes with 0-RTT if the server allows it)")
+	flags.Var(&cfg.doqALPN, "doq-alpn", "DoQ ALPN identifiers to offer, e.g. doq,doq-i02 (default all known)")
+	flags.Var(&cfg.quicVersions, "quic-versions", "QUIC versions to offer: 1, 2, draft29 (default quic-go's)")
+	flags.BoolVar(&cfg.zeroRTT, "0rtt", false, "resume QUIC connections with 0-RTT and address validation tokens, implies -session-resumption")
+	flags.IntVar(&cfg.localPort, "quic-local-port", 0, "local UDP port for QUIC connections, 0 picks a random port")
+	flags.StringVar(&cfg.qlogDir, "qlog-dir", "", "write the qlog of every QUIC connection to a file in this directory instead of the results")
+	flags.StringVar(&cfg.qlogFormat, "qlog-format", "sqlog", "format of the files in -qlog-dir: sqlog (streamed JSON text sequences) or qlog (JSON)")
+	flags.StringVar(&cfg.output, "output", "text", "output format: text or json")
+	flags.StringVar(&cfg.metricsListen, "metrics-listen", "", "serve the results in the Prometheus text format at /metrics on this address while running, e.g. :9153")
+
+	if err := flags.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return exitOK
+		}
+		return exitUsage
+	}
+	cfg.upstreams = append(cfg.upstreams, flags.Args()...)
+
+	if err := cfg.validate(); err != nil {
+		fmt.Fprintf(stderr, "dnsperf: %s\n", err)
+		return exitUsage
+	}
+
+	options, err := cfg.clientOptions()
+	if err != nil {
+		fmt.Fprintf(stderr, "dnsperf: %s\n", err)
+		return exitUsage
+	}
+
+	// every upstream gets its own source, this one only validates the queries up front
+	if _, err := cfg.querySource(); err != nil {
+		fmt.Fprintf(stderr, "dnsperf: %s\n", err)
+		return exitUsage
+	}
+
+	var exp *exporter.Exporter
+	if cfg.metricsListen != "" {
+		ln, err := net.Listen("tcp", cfg.metricsListen)
+		if err != nil {
+			fmt.Fprintf(stderr, "dnsperf: %s\n", err)
+			return exitFailure
+		}
+		defer ln.Close()
+		exp = exporter.NewExporter(nil)
+		go exporter.Serve(ln, exp)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	out := newPrinter(cfg.output, stdout)
+	failed := false
+	for _, upstream := range cfg.upstreams {
+		targets, err := cfg.targets(ctx, upstream, options)
+		if err != nil {
+			fmt.Fprintf(stderr, "dnsperf: %s: %s\n", upstream, err)
+			failed = true
+			continue
+		}
+		for _, target := range targets {
+			ok, err := runUpstream(ctx, cfg, target.name, target.client, out, exp)
+			if err != nil {
+				fmt.Fprintf(stderr, "dnsperf: %s: %s\n", target.name, err)
+				failed = true
+				continue
+			}
+			failed = failed || !ok
+		}
+	}
+
+	if failed {
+		return exitFailure
+	}
+	return exitOK
+}
+
+func (cfg *config) validate() error {
+	if len(cfg.upstreams) == 0 {
+		return fmt.Errorf("no upstream given")
+	}
+	if cfg.count <= 0 {
+		return fmt.Errorf("-count must be positive")
+	}
+	if cfg.concurrency <= 0 {
+		return fmt.Errorf("-concurrency must be positive")
+	}
+	if cfg.interval < 0 {
+		return fmt.Errorf("-interval must not be negative")
+	}
+	if (len(cfg.spkiPins) != 0 || cfg.authName != "") && cfg.verify != "strict" && cfg.verify != "opportunistic" && cfg.verify != "dane" {
+		return fmt.Errorf("-spki-pin and -auth-name require -verify strict, opportunistic or dane")
+	}
+	if cfg.verify == "dane" && cfg.daneServer == "" {
+		return fmt.Errorf("-verify dane requires -dane-resolver")
+	}
+	if cfg.localPort != 0 && cfg.dial == "happy-eyeballs" {
+		return fmt.Errorf("-quic-local-port can't be used with -dial happy-eyeballs")
+	}
+	if cfg.qlogFormat != string(clients.QLogFormatJSONSeq) && cfg.qlogFormat != string(clients.QLogFormatJSON) {
+		return fmt.Errorf("unknown qlog format %q", cfg.qlogFormat)
+	}
+	if cfg.output != "text" && cfg.output != "json" {
+		return fmt.Errorf("unknown output format %q", cfg.output)
+	}
+	return nil
+}
+
+func parseTLSVersion(s string) (uint16, error) {
+	switch s {
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("unknown TLS version %q", s)
+	}
+}
+
+func parseQUICVersion(s string) (quic.VersionNumber, error) {
+	switch strings.ToLower(s) {
+	case "1":
+		return quic.Version1, nil
+	case "2":
+		return quic.Version2, nil
+	case "draft29", "draft-29":
+		return quic.VersionDraft29, nil
+	default:
+		return 0, fmt.Errorf("unknown QUIC version %q", s)
+	}
+}
+
+func (cfg *config) clientOptions() (clients.Options, error) {
+	tlsOptions := &clients.TLSOptions{}
+
+	var err error
+	if tlsOptions.MinVersion, err = parseTLSVersion(cfg.tlsMin); err != nil {
+		return clients.Options{}, err
+	}
+	if tlsOptions.MaxVersion, err = parseTLSVersion(cfg.tlsMax); err != nil {
+		return clients.Options{}, err
+	}
+
+	switch cfg.verify {
+	case "full":
+	case "skip-hostname":
+		tlsOptions.SkipCommonName = true
+	case "none":
+		tlsOptions.InsecureSkipVerify = true
+	case "strict":
+		tlsOptions.Profile = clients.ProfileStrict
+	case "opportunistic":
+		tlsOptions.Profile = clients.ProfileOpportunistic
+	case "dane":
+		tlsOptions.Profile = clients.ProfileStrict
+	default:
+		return clients.Options{}, fmt.Errorf("unknown verification mode %q", cfg.verify)
+	}
+	tlsOptions.SPKIPins = cfg.spkiPins
+	tlsOptions.AuthDomainName = cfg.authName
+
+	if cfg.daneServer != "" {
+		tlsOptions.DANEResolver, err = clients.AddressToClient(cfg.daneServer, clients.Options{Timeout: cfg.timeout})
+		if err != nil {
+			return clients.Options{}, fmt.Errorf("invalid DANE resolver: %w", err)
+		}
+	}
+
+	if cfg.resumption || cfg.zeroRTT {
+		tlsOptions.ClientSessionCache = tls.NewLRUClientSessionCache(100)
+	}
+
+	quicOptions := &clients.QuicOptions{
+		LocalPort:  cfg.localPort,
+		QLogDir:    cfg.qlogDir,
+		QLogFormat: clients.QLogFormat(cfg.qlogFormat),
+	}
+	if len(cfg.doqALPN) != 0 {
+		versions := make([]clients.DoQVersion, 0, len(cfg.doqALPN))
+		for _, alpn := range cfg.doqALPN {
+			versions = append(versions, clients.DoQVersion(alpn))
+		}
+		quicOptions.AllowedVersions = &versions
+	}
+	for _, v := range cfg.quicVersions {
+		version, err := parseQUICVersion(v)
+		if err != nil {
+			return clients.Options{}, err
+		}
+		quicOptions.QuicVersions = append(quicOptions.QuicVersions, version)
+	}
+	if cfg.zeroRTT {
+		quicOptions.TokenStore = quic.NewLRUTokenStore(5, 50)
+	}
+
+	var padding clients.PaddingPolicy
+	switch cfg.padding {
+	case "none":
+		padding = clients.PaddingNone
+	case "block":
+		padding = clients.PaddingBlockLength
+	case "random":
+		padding = clients.PaddingRandom
+	default:
+		return clients.Options{}, fmt.Errorf("unknown padding policy %q", cfg.padding)
+	}
+
+	var dialPolicy clients.DialPolicy
+	switch cfg.dial {
+	case "sequential":
+		dialPolicy = clients.DialSequential
+	case "happy-eyeballs":
+		dialPolicy = clients.DialHappyEyeballs
+	case "ipv4":
+		dialPolicy = clients.DialIPv4Only
+	case "ipv6":
+		dialPolicy = clients.DialIPv6Only
+	case "prefer-ipv6":
+		dialPolicy = clients.DialPreferIPv6
+	default:
+		return clients.Options{}, fmt.Errorf("unknown dial policy %q", cfg.dial)
+	}
+
+	truncation := clients.TruncationReturn
+	if cfg.tcpFallback {
+		truncation = clients.TruncationRetryTCP
+	}
+
+	return clients.Options{
+		Timeout:         cfg.timeout,
+		Bootstrap:       cfg.bootstrap,
+		DialPolicy:      dialPolicy,
+		TLSOptions:      tlsOptions,
+		QuicOptions:     quicOptions,
+		ReuseConnection: cfg.reuse,
+		Padding:         padding,
+		Truncation:      truncation,
+		DNSCryptOptions: &clients.DNSCryptOptions{UseTCP: cfg.dnscryptTCP},
+	}, nil
+}
+
+func (cfg *config) querySource() (loadgen.Source, error) {
+	if cfg.queryFile != "" {
+		return queries.OpenFile(cfg.queryFile, queries.Options{Loop: true, DNSSECOK: cfg.dnssec})
+	}
+
+	query, err := queries.ParseQuery(strings.Join([]string{cfg.name, cfg.class, cfg.qtype}, " "))
+	if err != nil {
+		return nil, err
+	}
+	query.DNSSECOK = cfg.dnssec
+	return loadgen.RepeatQuery(query.Msg()), nil
+}
+
+type target struct {
+	name   string
+	client clients.DnsClient
+}
+
+// targets returns the client of the upstream or, with -per-address, one client per address named upstream[ip]
+func (cfg *config) targets(ctx context.Context, upstream string, options clients.Options) ([]target, error) {
+	if !cfg.perAddress {
+		client, err := clients.AddressToClient(upstream, options)
+		if err != nil {
+			return nil, err
+		}
+		return []target{{name: upstream, client: client}}, nil
+	}
+
+	addressClients, err := clients.AddressToClientsContext(ctx, upstream, options, nil)
+	if err != nil {
+		return nil, err
+	}
+	targets := make([]target, 0, len(addressClients))
+	for _, c := range addressClients {
+		targets = append(targets, target{name: upstream + "[" + c.IP.String() + "]", client: c.Client})
+	}
+	return targets, nil
+}
+
+// runUpstream sends all queries to a single upstream, it reports whether every exchange succeeded. The results are
+// observed by exp unless it is nil.
+func runUpstream(ctx context.Context, cfg *config, upstream string, client clients.DnsClient, out *printer, exp *exporter.Exporter) (bool, error) {
+	source, err := cfg.querySource()
+	if err != nil {
+		return false, err
+	}
+
+	loadOptions := loadgen.Options{
+		Mode:       loadgen.ClosedLoop,
+		Workers:    cfg.concurrency,
+		MaxQueries: cfg.count,
+		OnResult: func(query *dns.Msg, result *metrics.WithResponseOrError) {
+			out.result(upstream, query, result)
+			if exp != nil {
+				exp.Observe(upstream, result.GetMetrics())
+			}
+		},
+	}
+	if cfg.interval > 0 {
+		loadOptions.QPS = float64(time.Second) / float64(cfg.interval)
+	}
+
+	runner, err := loadgen.NewRunner(client, source, loadOptions)
+	if err != nil {
+		return false, err
+	}
+
+	summary, err := runner.Run(ctx)
+	if err != nil {
+		return false, err
+	}
+	out.summary(upstream, summary)
+
+	return summary.Errors == 0 && summary.Sent == cfg.count, nil
+}
+
+type printer struct {
+	mutex  sync.Mutex
+	format string
+	w      io.Writer
+}
+
+func newPrinter(format string, w io.Writer) *printer {
+	return &printer{format: format, w: w}
+}
+
+type jsonResult struct {
+	Upstream string          `json:"upstream"`
+	Query    string          `json:"query"`
+	Error    string          `json:"error,omitempty"`
+	Metrics  *metrics.Result `json:"metrics"`
+	Response *dns.Msg        `json:"response,omitempty"`
+}
+
+type jsonSummary struct {
+	Upstream string           `json:"upstream"`
+	Summary  *loadgen.Summary `json:"summary"`
+}
+
+func (p *printer) result(upstream string, query *dns.Msg, result *metrics.WithResponseOrError) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	m := result.GetMetrics()
+	question := ""
+	if len(query.Question) != 0 {
+		q := query.Question[0]
+		question = q.Name + " " + dns.ClassToString[q.Qclass] + " " + dns.TypeToString[q.Qtype]
+	}
+
+	if p.format == "json" {
+		r := jsonResult{Upstream: upstream, Query: question, Metrics: m, Response: result.GetResponse()}
+		if result.GetError() != nil {
+			r.Error = result.GetError().Error()
+		}
+		p.writeJSON(r)
+		return
+	}
+
+	line := fmt.Sprintf("%s %s: %s", upstream, question, m.Outcome)
+	if m.TotalTime != nil {
+		line += fmt.Sprintf(" total=%s", *m.TotalTime)
+	}
+	if m.QueryTime != nil {
+		line += fmt.Sprintf(" query=%s", *m.QueryTime)
+	}
+	for _, phase := range []struct {
+		name     string
+		duration *time.Duration
+	}{
+		{"bootstrap", m.BootstrapDuration},
+		{"tcp", m.TCPHandshakeDuration},
+		{"tls", m.TLSHandshakeDuration},
+		{"quic", m.QUICHandshakeDuration},
+	} {
+		if phase.duration != nil {
+			line += fmt.Sprintf(" %s=%s", phase.name, *phase.duration)
+		}
+	}
+	if m.ConnectionReused {
+		line += " reused"
+	}
+	if m.ConnectionRedialed {
+		line += " redialed"
+	}
+	if m.TLSDidResume {
+		line += " resumed"
+	}
+	if m.QUICUsed0RTT {
+		line += " 0rtt"
+	}
+	if m.QLogFile != nil {
+		line += fmt.Sprintf(" qlog=%s", *m.QLogFile)
+	}
+	if result.GetError() != nil {
+		line += fmt.Sprintf(" error=%q", result.GetError().Error())
+	}
+	fmt.Fprintln(p.w, line)
+}
+
+func (p *printer) summary(upstream string, summary *loadgen.Summary) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.format == "json" {
+		p.writeJSON(jsonSummary{Upstream: upstream, Summary: summary})
+		return
+	}
+
+	fmt.Fprintf(p.w, "%s: %d sent, %d responses, %d errors in %s\n",
+		upstream, summary.Sent, summary.Responses, summary.Errors, summary.Duration.Round(time.Millisecond))
+	if total := summary.Statistics.TotalTime; total != nil {
+		fmt.Fprintf(p.w, "%s: total min=%s mean=%s p50=%s p90=%s p99=%s max=%s\n",
+			upstream, total.Min, total.Mean, total.P50, total.P90, total.P99, total.Max)
+	}
+}
+
+func (p *printer) writeJSON(v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		fmt.Fprintf(p.w, "{\"error\": %q}\n", err.Error())
+		return
+	}
+	fmt.Fprintln(p.w, string(b))
+}
@@ -0,0 +1,45 @@
+package doqerr
+
+import "fmt"
+
+// ErrorCode is a DoQ application error code used to close connections and reset streams (RFC 9250 section 4.3)
+type ErrorCode uint64
+
+const (
+	NoError          ErrorCode = 0x0
+	InternalError    ErrorCode = 0x1
+	ProtocolError    ErrorCode = 0x2
+	RequestCancelled ErrorCode = 0x3
+	ExcessiveLoad    ErrorCode = 0x4
+	UnspecifiedError ErrorCode = 0x5
+	ErrorReserved    ErrorCode = 0xd098ea5e
+)
+
+// Source tells whether an error code was received for a single stream or for the whole connection
+type Source string
+
+const (
+	SourceStream     Source = "stream"
+	SourceConnection Source = "connection"
+)
+
+func (e ErrorCode) String() string {
+	switch e {
+	case NoError:
+		return "DOQ_NO_ERROR"
+	case InternalError:
+		return "DOQ_INTERNAL_ERROR"
+	case ProtocolError:
+		return "DOQ_PROTOCOL_ERROR"
+	case RequestCancelled:
+		return "DOQ_REQUEST_CANCELLED"
+	case ExcessiveLoad:
+		return "DOQ_EXCESSIVE_LOAD"
+	case UnspecifiedError:
+		return "DOQ_UNSPECIFIED_ERROR"
+	case ErrorReserved:
+		return "DOQ_ERROR_RESERVED"
+	default:
+		return fmt.Sprintf("unknown DoQ error code: %#x", uint64(e))
+	}
+}
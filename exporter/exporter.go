@@ -0,0 +1,251 @@
+package exporter
+
+import (
+	"fmt"
+	"github.com/mgranderath/dnsperf/metrics"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultBuckets are the upper bounds in seconds of the duration histograms
+var DefaultBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// upstreamKey identifies the series of an upstream
+type upstreamKey struct {
+	upstream string
+	protocol string
+}
+
+type phaseKey struct {
+	upstreamKey
+	phase string
+}
+
+type outcomeKey struct {
+	upstreamKey
+	outcome string
+	detail  string
+}
+
+type histogram struct {
+	buckets []uint64
+	count   uint64
+	sum     float64
+}
+
+// Exporter collects results of long-running probes and exposes them in the Prometheus text format
+type Exporter struct {
+	mutex   sync.Mutex
+	buckets []float64
+
+	durations map[phaseKey]*histogram
+	outcomes  map[outcomeKey]uint64
+
+	tlsVersions  map[upstreamKey]uint16
+	quicVersions map[upstreamKey]uint64
+	quicUsed0RTT map[upstreamKey]bool
+}
+
+// NewExporter creates an Exporter, nil buckets means DefaultBuckets
+func NewExporter(buckets []float64) *Exporter {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+
+	return &Exporter{
+		buckets:      buckets,
+		durations:    make(map[phaseKey]*histogram),
+		outcomes:     make(map[outcomeKey]uint64),
+		tlsVersions:  make(map[upstreamKey]uint16),
+		quicVersions: make(map[upstreamKey]uint64),
+		quicUsed0RTT: make(map[upstreamKey]bool),
+	}
+}
+
+// Observe records the result of an exchange with upstream, the protocol label is the scheme of the upstream URL
+func (e *Exporter) Observe(upstream string, result *metrics.Result) {
+	key := upstreamKey{upstream: upstream}
+	if u, err := url.Parse(upstream); err == nil {
+		key.protocol = u.Scheme
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.observeDuration(key, "udp_socket_setup", result.UDPSocketSetupDuration)
+	e.observeDuration(key, "tcp_handshake", result.TCPHandshakeDuration)
+	e.observeDuration(key, "tls_handshake", result.TLSHandshakeDuration)
+	e.observeDuration(key, "quic_handshake", result.QUICHandshakeDuration)
+	e.observeDuration(key, "query", result.QueryTime)
+	e.observeDuration(key, "total", result.TotalTime)
+
+	e.outcomes[outcomeKey{upstreamKey: key, outcome: string(result.Outcome.Class), detail: result.Outcome.Detail}]++
+
+	if result.TLSVersion != nil {
+		e.tlsVersions[key] = *result.TLSVersion
+	}
+	if result.QUICVersion != nil {
+		e.quicVersions[key] = *result.QUICVersion
+		e.quicUsed0RTT[key] = result.QUICUsed0RTT
+	}
+}
+
+func (e *Exporter) observeDuration(key upstreamKey, phase string, d *time.Duration) {
+	if d == nil {
+		return
+	}
+
+	k := phaseKey{upstreamKey: key, phase: phase}
+	h, ok := e.durations[k]
+	if !ok {
+		h = &histogram{buckets: make([]uint64, len(e.buckets))}
+		e.durations[k] = h
+	}
+
+	seconds := d.Seconds()
+	for i, bound := range e.buckets {
+		if seconds <= bound {
+			h.buckets[i]++
+		}
+	}
+	h.count++
+	h.sum += seconds
+}
+
+// ServeHTTP writes all metrics in the Prometheus text exposition format
+func (e *Exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_ = e.Write(w)
+}
+
+// ListenAndServe serves the metrics of e on addr at /metrics
+func ListenAndServe(addr string, e *Exporter) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return Serve(ln, e)
+}
+
+// Serve serves the metrics of e at /metrics on connections accepted by ln, it returns when ln is closed
+func Serve(ln net.Listener, e *Exporter) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", e)
+	return http.Serve(ln, mux)
+}
+
+// Write writes all metrics in the Prometheus text exposition format
+func (e *Exporter) Write(w io.Writer) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	var b strings.Builder
+
+	writeHeader(&b, "dnsperf_phase_duration_seconds", "histogram", "Duration of the phases of an exchange.")
+	phases := make([]phaseKey, 0, len(e.durations))
+	for k := range e.durations {
+		phases = append(phases, k)
+	}
+	sort.Slice(phases, func(i, j int) bool {
+		return phaseKeyString(phases[i]) < phaseKeyString(phases[j])
+	})
+	for _, k := range phases {
+		h := e.durations[k]
+		labels := upstreamLabels(k.upstreamKey) + `,phase="` + escape(k.phase) + `"`
+		for i, bound := range e.buckets {
+			fmt.Fprintf(&b, "dnsperf_phase_duration_seconds_bucket{%s,le=\"%s\"} %d\n", labels, formatFloat(bound), h.buckets[i])
+		}
+		fmt.Fprintf(&b, "dnsperf_phase_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, h.count)
+		fmt.Fprintf(&b, "dnsperf_phase_duration_seconds_sum{%s} %s\n", labels, formatFloat(h.sum))
+		fmt.Fprintf(&b, "dnsperf_phase_duration_seconds_count{%s} %d\n", labels, h.count)
+	}
+
+	writeHeader(&b, "dnsperf_exchanges_total", "counter", "Exchanges by outcome class and detail.")
+	outcomes := make([]outcomeKey, 0, len(e.outcomes))
+	for k := range e.outcomes {
+		outcomes = append(outcomes, k)
+	}
+	sort.Slice(outcomes, func(i, j int) bool {
+		return outcomeKeyString(outcomes[i]) < outcomeKeyString(outcomes[j])
+	})
+	for _, k := range outcomes {
+		fmt.Fprintf(&b, "dnsperf_exchanges_total{%s,outcome=\"%s\",detail=\"%s\"} %d\n",
+			upstreamLabels(k.upstreamKey), escape(k.outcome), escape(k.detail), e.outcomes[k])
+	}
+
+	writeHeader(&b, "dnsperf_tls_version", "gauge", "TLS version negotiated by the latest exchange, e.g. 772 for TLS 1.3.")
+	tlsUpstreams := make([]upstreamKey, 0, len(e.tlsVersions))
+	for k := range e.tlsVersions {
+		tlsUpstreams = append(tlsUpstreams, k)
+	}
+	sortUpstreams(tlsUpstreams)
+	for _, k := range tlsUpstreams {
+		fmt.Fprintf(&b, "dnsperf_tls_version{%s} %d\n", upstreamLabels(k), e.tlsVersions[k])
+	}
+
+	writeHeader(&b, "dnsperf_quic_version", "gauge", "QUIC version negotiated by the latest exchange.")
+	quicUpstreams := make([]upstreamKey, 0, len(e.quicVersions))
+	for k := range e.quicVersions {
+		quicUpstreams = append(quicUpstreams, k)
+	}
+	sortUpstreams(quicUpstreams)
+	for _, k := range quicUpstreams {
+		fmt.Fprintf(&b, "dnsperf_quic_version{%s} %d\n", upstreamLabels(k), e.quicVersions[k])
+	}
+
+	writeHeader(&b, "dnsperf_quic_used_0rtt", "gauge", "Whether the latest QUIC exchange used 0-RTT.")
+	for _, k := range quicUpstreams {
+		used := 0
+		if e.quicUsed0RTT[k] {
+			used = 1
+		}
+		fmt.Fprintf(&b, "dnsperf_quic_used_0rtt{%s} %d\n", upstreamLabels(k), used)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func writeHeader(b *strings.Builder, name string, metricType string, help string) {
+	fmt.Fprintf(b, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(b, "# TYPE %s %s\n", name, metricType)
+}
+
+func upstreamLabels(k upstreamKey) string {
+	return `upstream="` + escape(k.upstream) + `",protocol="` + escape(k.protocol) + `"`
+}
+
+func phaseKeyString(k phaseKey) string {
+	return k.upstream + "\x00" + k.protocol + "\x00" + k.phase
+}
+
+func outcomeKeyString(k outcomeKey) string {
+	return k.upstream + "\x00" + k.protocol + "\x00" + k.outcome + "\x00" + k.detail
+}
+
+func sortUpstreams(keys []upstreamKey) {
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].upstream != keys[j].upstream {
+			return keys[i].upstream < keys[j].upstream
+		}
+		return keys[i].protocol < keys[j].protocol
+	})
+}
+
+// escape escapes a label value as required by the exposition format
+func escape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,106 @@
+package exporter
+
+import (
+	"github.com/mgranderath/dnsperf/metrics"
+	"strings"
+	"testing"
+	"time"
+)
+
+func duration(d time.Duration) *time.Duration {
+	return &d
+}
+
+// testResults are observed by the golden test, the DoT upstream has a label value that needs escaping
+func testResults() []struct {
+	upstream string
+	result   *metrics.Result
+} {
+	tls13, quicVersion := uint16(772), uint64(1)
+	return []struct {
+		upstream string
+		result   *metrics.Result
+	}{
+		{"udp://9.9.9.9:53", &metrics.Result{
+			QueryTime: duration(5 * time.Millisecond),
+			TotalTime: duration(5 * time.Millisecond),
+			Outcome:   metrics.Outcome{Class: metrics.OutcomeRcode, Detail: "NOERROR"},
+		}},
+		{"udp://9.9.9.9:53", &metrics.Result{
+			QueryTime: duration(50 * time.Millisecond),
+			TotalTime: duration(500 * time.Millisecond),
+			Outcome:   metrics.Outcome{Class: metrics.OutcomeRcode, Detail: "NXDOMAIN"},
+		}},
+		{"tls://dns.example:853", &metrics.Result{
+			TLSVersion: &tls13,
+			TotalTime:  duration(20 * time.Millisecond),
+			Outcome:    metrics.Outcome{Class: metrics.OutcomeError, Detail: "back\\slash \"quoted\"\nnewline"},
+		}},
+		{"quic://dns.example:853", &metrics.Result{
+			QUICVersion:  &quicVersion,
+			QUICUsed0RTT: true,
+			TotalTime:    duration(time.Second),
+			Outcome:      metrics.Outcome{Class: metrics.OutcomeRcode, Detail: "NOERROR"},
+		}},
+	}
+}
+
+const wantExposition = `# HELP dnsperf_phase_duration_seconds Duration of the phases of an exchange.
+# TYPE dnsperf_phase_duration_seconds histogram
+dnsperf_phase_duration_seconds_bucket{upstream="quic://dns.example:853",protocol="quic",phase="total",le="0.01"} 0
+dnsperf_phase_duration_seconds_bucket{upstream="quic://dns.example:853",protocol="quic",phase="total",le="0.1"} 0
+dnsperf_phase_duration_seconds_bucket{upstream="quic://dns.example:853",protocol="quic",phase="total",le="+Inf"} 1
+dnsperf_phase_duration_seconds_sum{upstream="quic://dns.example:853",protocol="quic",phase="total"} 1
+dnsperf_phase_duration_seconds_count{upstream="quic://dns.example:853",protocol="quic",phase="total"} 1
+dnsperf_phase_duration_seconds_bucket{upstream="tls://dns.example:853",protocol="tls",phase="total",le="0.01"} 0
+dnsperf_phase_duration_seconds_bucket{upstream="tls://dns.example:853",protocol="tls",phase="total",le="0.1"} 1
+dnsperf_phase_duration_seconds_bucket{upstream="tls://dns.example:853",protocol="tls",phase="total",le="+Inf"} 1
+dnsperf_phase_duration_seconds_sum{upstream="tls://dns.example:853",protocol="tls",phase="total"} 0.02
+dnsperf_phase_duration_seconds_count{upstream="tls://dns.example:853",protocol="tls",phase="total"} 1
+dnsperf_phase_duration_seconds_bucket{upstream="udp://9.9.9.9:53",protocol="udp",phase="query",le="0.01"} 1
+dnsperf_phase_duration_seconds_bucket{upstream="udp://9.9.9.9:53",protocol="udp",phase="query",le="0.1"} 2
+dnsperf_phase_duration_seconds_bucket{upstream="udp://9.9.9.9:53",protocol="udp",phase="query",le="+Inf"} 2
+dnsperf_phase_duration_seconds_sum{upstream="udp://9.9.9.9:53",protocol="udp",phase="query"} 0.055
+dnsperf_phase_duration_seconds_count{upstream="udp://9.9.9.9:53",protocol="udp",phase="query"} 2
+dnsperf_phase_duration_seconds_bucket{upstream="udp://9.9.9.9:53",protocol="udp",phase="total",le="0.01"} 1
+dnsperf_phase_duration_seconds_bucket{upstream="udp://9.9.9.9:53",protocol="udp",phase="total",le="0.1"} 1
+dnsperf_phase_duration_seconds_bucket{upstream="udp://9.9.9.9:53",protocol="udp",phase="total",le="+Inf"} 2
+dnsperf_phase_duration_seconds_sum{upstream="udp://9.9.9.9:53",protocol="udp",phase="total"} 0.505
+dnsperf_phase_duration_seconds_count{upstream="udp://9.9.9.9:53",protocol="udp",phase="total"} 2
+# HELP dnsperf_exchanges_total Exchanges by outcome class and detail.
+# TYPE dnsperf_exchanges_total counter
+dnsperf_exchanges_total{upstream="quic://dns.example:853",protocol="quic",outcome="rcode",detail="NOERROR"} 1
+dnsperf_exchanges_total{upstream="tls://dns.example:853",protocol="tls",outcome="error",detail="back\\slash \"quoted\"\nnewline"} 1
+dnsperf_exchanges_total{upstream="udp://9.9.9.9:53",protocol="udp",outcome="rcode",detail="NOERROR"} 1
+dnsperf_exchanges_total{upstream="udp://9.9.9.9:53",protocol="udp",outcome="rcode",detail="NXDOMAIN"} 1
+# HELP dnsperf_tls_version TLS version negotiated by the latest exchange, e.g. 772 for TLS 1.3.
+# TYPE dnsperf_tls_version gauge
+dnsperf_tls_version{upstream="tls://dns.example:853",protocol="tls"} 772
+# HELP dnsperf_quic_version QUIC version negotiated by the latest exchange.
+# TYPE dnsperf_quic_version gauge
+dnsperf_quic_version{upstream="quic://dns.example:853",protocol="quic"} 1
+# HELP dnsperf_quic_used_0rtt Whether the latest QUIC exchange used 0-RTT.
+# TYPE dnsperf_quic_used_0rtt gauge
+dnsperf_quic_used_0rtt{upstream="quic://dns.example:853",protocol="quic"} 1
+`
+
+func TestExporterWrite(t *testing.T) {
+	results := testResults()
+
+	// the output does not depend on the order of the observations
+	forward, backward := NewExporter([]float64{0.1, 0.01}), NewExporter([]float64{0.01, 0.1})
+	for i := range results {
+		forward.Observe(results[i].upstream, results[i].result)
+		backward.Observe(results[len(results)-1-i].upstream, results[len(results)-1-i].result)
+	}
+
+	for _, e := range []*Exporter{forward, forward, backward} {
+		var b strings.Builder
+		if err := e.Write(&b); err != nil {
+			t.Fatal(err)
+		}
+		if got := b.String(); got != wantExposition {
+			t.Errorf("Write() = %s, want %s", got, wantExposition)
+		}
+	}
+}
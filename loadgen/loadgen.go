@@ -0,0 +1,232 @@
+package loadgen
+
+import (
+	"context"
+	"errors"
+	"github.com/mgranderath/dnsperf/clients"
+	"github.com/mgranderath/dnsperf/metrics"
+	"github.com/miekg/dns"
+	"io"
+	"sync"
+	"time"
+)
+
+type Mode int
+
+const (
+	// OpenLoop sends queries at a constant arrival rate, independent of how fast the upstream answers.
+	// A query that is due while all workers are busy is dropped.
+	OpenLoop Mode = iota
+
+	// ClosedLoop keeps a fixed number of queries in flight, each worker sends its next query as soon
+	// as the previous one finished (optionally capped at the target rate).
+	ClosedLoop
+)
+
+func (m Mode) String() string {
+	switch m {
+	case OpenLoop:
+		return "open-loop"
+	case ClosedLoop:
+		return "closed-loop"
+	default:
+		return "unknown"
+	}
+}
+
+// Source provides the queries sent during a run, Next returns io.EOF once it is exhausted
+type Source interface {
+	Next() (*dns.Msg, error)
+}
+
+// SourceFunc adapts an ordinary function to a Source
+type SourceFunc func() (*dns.Msg, error)
+
+func (f SourceFunc) Next() (*dns.Msg, error) {
+	return f()
+}
+
+// RepeatQuery returns a Source that yields m endlessly
+func RepeatQuery(m *dns.Msg) Source {
+	return SourceFunc(func() (*dns.Msg, error) {
+		return m, nil
+	})
+}
+
+type Options struct {
+	// Mode selects open-loop (constant arrival) or closed-loop (fixed concurrency) load
+	Mode Mode
+
+	// Workers is the maximum number of queries in flight
+	Workers int
+
+	// QPS is the target rate in queries per second, it is required in open-loop mode.
+	// In closed-loop mode 0 means that workers send as fast as the upstream answers.
+	QPS float64
+
+	// Duration bounds the run, 0 means no limit
+	Duration time.Duration
+
+	// MaxQueries bounds the number of queries taken from the source (sent or dropped), 0 means no limit
+	MaxQueries int64
+
+	// QueryTimeout bounds every single exchange, 0 means the client's own timeout applies
+	QueryTimeout time.Duration
+
+	// OnResult is called for every finished exchange, possibly from several workers at the same time
+	OnResult func(query *dns.Msg, result *metrics.WithResponseOrError)
+}
+
+// Runner drives a DnsClient with queries from a Source
+type Runner struct {
+	client  clients.DnsClient
+	source  Source
+	options Options
+
+	sourceMutex sync.Mutex
+	sent        int64
+}
+
+func NewRunner(client clients.DnsClient, source Source, options Options) (*Runner, error) {
+	if client == nil || source == nil {
+		return nil, errors.New("loadgen requires a client and a query source")
+	}
+	if options.Workers <= 0 {
+		return nil, errors.New("loadgen requires at least one worker")
+	}
+	if options.QPS < 0 {
+		return nil, errors.New("loadgen requires a non-negative target rate")
+	}
+	if options.Mode == OpenLoop && options.QPS == 0 {
+		return nil, errors.New("open-loop mode requires a target rate")
+	}
+
+	return &Runner{
+		client:  client,
+		source:  source,
+		options: options,
+	}, nil
+}
+
+// Run sends queries until the source is exhausted, Duration or MaxQueries is reached or ctx is done.
+// Queries in flight when the run ends are waited for, unless ctx itself is done.
+func (r *Runner) Run(ctx context.Context) (*Summary, error) {
+	dispatchCtx := ctx
+	if r.options.Duration > 0 {
+		var cancel context.CancelFunc
+		dispatchCtx, cancel = context.WithTimeout(ctx, r.options.Duration)
+		defer cancel()
+	}
+
+	aggregator := newAggregator(r.options)
+	queries := make(chan *dns.Msg)
+
+	var wg sync.WaitGroup
+	for i := 0; i < r.options.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.work(ctx, queries, aggregator)
+		}()
+	}
+
+	err := r.dispatch(dispatchCtx, queries, aggregator)
+	close(queries)
+	wg.Wait()
+
+	return aggregator.summary(), err
+}
+
+// dispatch hands queries to the workers at the target rate
+func (r *Runner) dispatch(ctx context.Context, queries chan<- *dns.Msg, aggregator *aggregator) error {
+	var interval time.Duration
+	if r.options.QPS > 0 {
+		interval = time.Duration(float64(time.Second) / r.options.QPS)
+	}
+
+	next := time.Now()
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		// the next query is fetched before waiting, so that a run ends as soon as the last query is sent
+		m, err := r.next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if m == nil {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-timer.C:
+		}
+
+		if r.options.Mode == OpenLoop {
+			select {
+			case queries <- m:
+			default:
+				aggregator.drop()
+			}
+		} else {
+			select {
+			case queries <- m:
+			case <-ctx.Done():
+				return nil
+			}
+		}
+
+		// scheduling relative to the start keeps the average rate even if a single tick is late
+		next = next.Add(interval)
+		timer.Reset(time.Until(next))
+	}
+}
+
+// next returns a private copy of the next query with a fresh ID, or nil once MaxQueries is reached
+func (r *Runner) next() (*dns.Msg, error) {
+	r.sourceMutex.Lock()
+	defer r.sourceMutex.Unlock()
+
+	if r.options.MaxQueries > 0 && r.sent >= r.options.MaxQueries {
+		return nil, nil
+	}
+
+	m, err := r.source.Next()
+	if err != nil {
+		return nil, err
+	}
+	r.sent++
+
+	// clients modify the message (e.g. DoQ resets the ID), so concurrent exchanges must not share it
+	m = m.Copy()
+	m.Id = dns.Id()
+	return m, nil
+}
+
+func (r *Runner) work(ctx context.Context, queries <-chan *dns.Msg, aggregator *aggregator) {
+	phases := metrics.NewAggregator()
+	defer aggregator.merge(phases)
+
+	for m := range queries {
+		queryCtx := ctx
+		var cancel context.CancelFunc = func() {}
+		if r.options.QueryTimeout > 0 {
+			queryCtx, cancel = context.WithTimeout(ctx, r.options.QueryTimeout)
+		}
+
+		result := r.client.ExchangeContext(queryCtx, m)
+		cancel()
+
+		resultMetrics := result.GetMetrics()
+		phases.Add(resultMetrics)
+		aggregator.add(result, resultMetrics)
+		if r.options.OnResult != nil {
+			r.options.OnResult(m, result)
+		}
+	}
+}
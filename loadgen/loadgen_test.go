@@ -0,0 +1,125 @@
+package loadgen
+
+import (
+	"context"
+	"github.com/mgranderath/dnsperf/metrics"
+	"github.com/miekg/dns"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeClient answers every query after delay and tracks how many exchanges are in flight
+type fakeClient struct {
+	delay time.Duration
+
+	exchanges   int64
+	inFlight    int64
+	maxInFlight int64
+}
+
+func (c *fakeClient) Exchange(m *dns.Msg) *metrics.WithResponseOrError {
+	return c.ExchangeContext(context.Background(), m)
+}
+
+func (c *fakeClient) ExchangeContext(ctx context.Context, m *dns.Msg) *metrics.WithResponseOrError {
+	atomic.AddInt64(&c.exchanges, 1)
+	inFlight := atomic.AddInt64(&c.inFlight, 1)
+	defer atomic.AddInt64(&c.inFlight, -1)
+	for {
+		max := atomic.LoadInt64(&c.maxInFlight)
+		if inFlight <= max || atomic.CompareAndSwapInt64(&c.maxInFlight, max, inFlight) {
+			break
+		}
+	}
+
+	collector := metrics.NewCollector()
+	collector.ExchangeStarted()
+	select {
+	case <-time.After(c.delay):
+	case <-ctx.Done():
+		return collector.WithError(ctx.Err())
+	}
+	collector.ExchangeFinished()
+	return collector.WithResponse(new(dns.Msg).SetReply(m))
+}
+
+func testQuery() *dns.Msg {
+	m := new(dns.Msg)
+	m.SetQuestion("example.org.", dns.TypeA)
+	return m
+}
+
+func TestRunnerMaxQueries(t *testing.T) {
+	tests := []struct {
+		name    string
+		options Options
+	}{
+		{"closed-loop", Options{Mode: ClosedLoop, Workers: 4, MaxQueries: 25}},
+		{"closed-loop rate", Options{Mode: ClosedLoop, Workers: 4, QPS: 1000, MaxQueries: 25}},
+		{"open-loop", Options{Mode: OpenLoop, Workers: 4, QPS: 1000, MaxQueries: 25}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &fakeClient{}
+			runner, err := NewRunner(client, RepeatQuery(testQuery()), tt.options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			summary, err := runner.Run(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// open-loop queries that find no idle worker are dropped, they are still taken from the source
+			if got := summary.Sent + summary.Dropped; got != tt.options.MaxQueries {
+				t.Errorf("Sent + Dropped = %d, want %d", got, tt.options.MaxQueries)
+			}
+			if exchanges := atomic.LoadInt64(&client.exchanges); exchanges != summary.Sent {
+				t.Errorf("exchanges = %d, want Sent = %d", exchanges, summary.Sent)
+			}
+			if tt.options.Mode == ClosedLoop && summary.Dropped != 0 {
+				t.Errorf("Dropped = %d, want 0", summary.Dropped)
+			}
+		})
+	}
+}
+
+func TestRunnerOpenLoopDropsWhenWorkersBusy(t *testing.T) {
+	// the first exchange of every worker outlasts sending all queries, every other query finds the workers busy
+	client := &fakeClient{delay: 500 * time.Millisecond}
+	runner, err := NewRunner(client, RepeatQuery(testQuery()), Options{Mode: OpenLoop, Workers: 2, QPS: 1000, MaxQueries: 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	summary, err := runner.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if summary.Sent > 2 {
+		t.Errorf("Sent = %d, want at most 2", summary.Sent)
+	}
+	if summary.Sent+summary.Dropped != 20 {
+		t.Errorf("Sent + Dropped = %d, want 20", summary.Sent+summary.Dropped)
+	}
+}
+
+func TestRunnerClosedLoopConcurrency(t *testing.T) {
+	client := &fakeClient{delay: time.Millisecond}
+	runner, err := NewRunner(client, RepeatQuery(testQuery()), Options{Mode: ClosedLoop, Workers: 3, MaxQueries: 60})
+	if err != nil {
+		t.Fatal(err)
+	}
+	summary, err := runner.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if maxInFlight := atomic.LoadInt64(&client.maxInFlight); maxInFlight > 3 || maxInFlight == 0 {
+		t.Errorf("maximum in flight = %d, want 1 to 3", maxInFlight)
+	}
+	if summary.Sent != 60 || summary.Responses != 60 {
+		t.Errorf("Sent = %d, Responses = %d, want 60", summary.Sent, summary.Responses)
+	}
+}
@@ -0,0 +1,99 @@
+package loadgen
+
+import (
+	"github.com/mgranderath/dnsperf/metrics"
+	"sync"
+	"time"
+)
+
+// Summary aggregates the results of a run
+type Summary struct {
+	Mode      string    `json:"mode"`
+	Workers   int       `json:"workers"`
+	TargetQPS float64   `json:"target_qps,omitempty"`
+	StartTime time.Time `json:"start_time"`
+
+	Duration    time.Duration `json:"duration"`
+	AchievedQPS float64       `json:"achieved_qps"`
+
+	// Sent counts exchanges that were started, Dropped counts open-loop queries that found no idle worker
+	Sent    int64 `json:"sent"`
+	Dropped int64 `json:"dropped"`
+
+	Responses         int64 `json:"responses"`
+	Errors            int64 `json:"errors"`
+	Cancelled         int64 `json:"cancelled"`
+	TimedOut          int64 `json:"timed_out"`
+	ConnectionsReused int64 `json:"connections_reused"`
+
+	// Statistics holds the latency distribution of every phase and the count of every outcome
+	Statistics *metrics.AggregateResult `json:"statistics"`
+}
+
+type aggregator struct {
+	mutex sync.Mutex
+
+	result Summary
+	phases *metrics.Aggregator
+}
+
+func newAggregator(options Options) *aggregator {
+	return &aggregator{
+		result: Summary{
+			Mode:      options.Mode.String(),
+			Workers:   options.Workers,
+			TargetQPS: options.QPS,
+			StartTime: time.Now(),
+		},
+		phases: metrics.NewAggregator(),
+	}
+}
+
+func (a *aggregator) drop() {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.result.Dropped++
+}
+
+// add counts the outcome of an exchange, its timings are recorded by the worker's own metrics.Aggregator
+func (a *aggregator) add(result *metrics.WithResponseOrError, m *metrics.Result) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.result.Sent++
+	if result.GetResponse() != nil {
+		a.result.Responses++
+	}
+	if result.GetError() != nil {
+		a.result.Errors++
+	}
+	if m.Cancelled {
+		a.result.Cancelled++
+	}
+	if m.TimedOut {
+		a.result.TimedOut++
+	}
+	if m.ConnectionReused {
+		a.result.ConnectionsReused++
+	}
+}
+
+// merge combines the phase timings collected by a worker
+func (a *aggregator) merge(phases *metrics.Aggregator) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.phases.Merge(phases)
+}
+
+func (a *aggregator) summary() *Summary {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	summary := a.result
+	summary.Duration = time.Since(summary.StartTime)
+	if summary.Duration > 0 {
+		summary.AchievedQPS = float64(summary.Sent) / summary.Duration.Seconds()
+	}
+	summary.Statistics = a.phases.Result()
+	return &summary
+}
@@ -0,0 +1,124 @@
+package metrics
+
+import (
+	"time"
+)
+
+// PhaseStatistics summarises the durations of a single phase over many results
+type PhaseStatistics struct {
+	Count  uint64        `json:"count"`
+	Min    time.Duration `json:"min"`
+	Max    time.Duration `json:"max"`
+	Mean   time.Duration `json:"mean"`
+	StdDev time.Duration `json:"stddev"`
+	P50    time.Duration `json:"p50"`
+	P90    time.Duration `json:"p90"`
+	P99    time.Duration `json:"p99"`
+	P999   time.Duration `json:"p99_9"`
+}
+
+func newPhaseStatistics(h *Histogram) *PhaseStatistics {
+	if h.Count() == 0 {
+		return nil
+	}
+	return &PhaseStatistics{
+		Count:  h.Count(),
+		Min:    h.Min(),
+		Max:    h.Max(),
+		Mean:   h.Mean(),
+		StdDev: h.StdDev(),
+		P50:    h.Percentile(50),
+		P90:    h.Percentile(90),
+		P99:    h.Percentile(99),
+		P999:   h.Percentile(99.9),
+	}
+}
+
+// AggregateResult holds the statistics of every phase, phases that never occurred are omitted
+type AggregateResult struct {
+	Results uint64 `json:"results"`
+
+	// Outcomes counts the results per outcome, keyed by Outcome.String()
+	Outcomes map[string]uint64 `json:"outcomes,omitempty"`
+
+	UDPSocketSetupDuration *PhaseStatistics `json:"udp_socket_setup_duration,omitempty"`
+	TCPHandshakeDuration   *PhaseStatistics `json:"tcp_handshake_duration,omitempty"`
+	TLSHandshakeDuration   *PhaseStatistics `json:"tls_handshake_duration,omitempty"`
+	QUICHandshakeDuration  *PhaseStatistics `json:"quic_handshake_duration,omitempty"`
+	QueryTime              *PhaseStatistics `json:"query_time,omitempty"`
+	TotalTime              *PhaseStatistics `json:"total_time,omitempty"`
+}
+
+// Aggregator ingests many results and keeps a histogram per phase. Aggregators filled by concurrent
+// workers can be combined with Merge. It is not safe for concurrent use.
+type Aggregator struct {
+	results  uint64
+	outcomes map[string]uint64
+
+	udpSocketSetup *Histogram
+	tcpHandshake   *Histogram
+	tlsHandshake   *Histogram
+	quicHandshake  *Histogram
+	query          *Histogram
+	total          *Histogram
+}
+
+func NewAggregator() *Aggregator {
+	return &Aggregator{
+		outcomes:       make(map[string]uint64),
+		udpSocketSetup: NewHistogram(),
+		tcpHandshake:   NewHistogram(),
+		tlsHandshake:   NewHistogram(),
+		quicHandshake:  NewHistogram(),
+		query:          NewHistogram(),
+		total:          NewHistogram(),
+	}
+}
+
+func record(h *Histogram, d *time.Duration) {
+	if d != nil {
+		h.Record(*d)
+	}
+}
+
+func (a *Aggregator) Add(r *Result) {
+	a.results++
+	a.outcomes[r.Outcome.String()]++
+	record(a.udpSocketSetup, r.UDPSocketSetupDuration)
+	record(a.tcpHandshake, r.TCPHandshakeDuration)
+	record(a.tlsHandshake, r.TLSHandshakeDuration)
+	record(a.quicHandshake, r.QUICHandshakeDuration)
+	record(a.query, r.QueryTime)
+	record(a.total, r.TotalTime)
+}
+
+func (a *Aggregator) Merge(other *Aggregator) {
+	a.results += other.results
+	for outcome, count := range other.outcomes {
+		a.outcomes[outcome] += count
+	}
+	a.udpSocketSetup.Merge(other.udpSocketSetup)
+	a.tcpHandshake.Merge(other.tcpHandshake)
+	a.tlsHandshake.Merge(other.tlsHandshake)
+	a.quicHandshake.Merge(other.quicHandshake)
+	a.query.Merge(other.query)
+	a.total.Merge(other.total)
+}
+
+func (a *Aggregator) Result() *AggregateResult {
+	outcomes := make(map[string]uint64, len(a.outcomes))
+	for outcome, count := range a.outcomes {
+		outcomes[outcome] = count
+	}
+
+	return &AggregateResult{
+		Results:                a.results,
+		Outcomes:               outcomes,
+		UDPSocketSetupDuration: newPhaseStatistics(a.udpSocketSetup),
+		TCPHandshakeDuration:   newPhaseStatistics(a.tcpHandshake),
+		TLSHandshakeDuration:   newPhaseStatistics(a.tlsHandshake),
+		QUICHandshakeDuration:  newPhaseStatistics(a.quicHandshake),
+		QueryTime:              newPhaseStatistics(a.query),
+		TotalTime:              newPhaseStatistics(a.total),
+	}
+}
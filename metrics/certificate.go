@@ -0,0 +1,101 @@
+package metrics
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"time"
+)
+
+// TLSAuthentication is the result of authenticating a server with an RFC 8310 usage profile
+type TLSAuthentication string
+
+const (
+	TLSAuthenticationSucceeded TLSAuthentication = "succeeded"
+	TLSAuthenticationFailed    TLSAuthentication = "failed"
+
+	// TLSAuthenticationSkipped is used by the opportunistic profile if it has nothing to authenticate the server with
+	TLSAuthenticationSkipped TLSAuthentication = "skipped"
+)
+
+// DANEResult is the result of authenticating a server with its TLSA records
+type DANEResult string
+
+const (
+	DANEMatched      DANEResult = "matched"
+	DANENoMatch      DANEResult = "no_match"
+	DANENoRecords    DANEResult = "no_records"
+	DANEInsecure     DANEResult = "insecure"
+	DANELookupFailed DANEResult = "lookup_failed"
+)
+
+// TLSCertificate describes the certificate a DoT, DoH or DoQ server presented
+type TLSCertificate struct {
+	Subject      string   `json:"subject"`
+	DNSNames     []string `json:"dns_names,omitempty"`
+	IPAddresses  []string `json:"ip_addresses,omitempty"`
+	Issuer       string   `json:"issuer"`
+	SerialNumber string   `json:"serial_number"`
+
+	NotBefore time.Time `json:"not_before"`
+	NotAfter  time.Time `json:"not_after"`
+	// ExpiresIn is the time left until NotAfter when the connection was established, negative once it expired
+	ExpiresIn time.Duration `json:"expires_in"`
+
+	// KeyType is RSA, ECDSA or Ed25519, KeySize the size of the key in bits
+	KeyType string `json:"key_type"`
+	KeySize int    `json:"key_size"`
+
+	// SPKISHA256 is the base64 encoded SHA-256 hash of the SubjectPublicKeyInfo, the format of SPKI pins (RFC 7469)
+	SPKISHA256 string `json:"spki_sha256"`
+
+	// ChainLength is the number of certificates the server sent, including the leaf
+	ChainLength int `json:"chain_length"`
+
+	// OCSPStapled is set when the server stapled an OCSP response to the certificate
+	OCSPStapled bool `json:"ocsp_stapled"`
+}
+
+// newTLSCertificate describes the leaf certificate of state, it returns nil if the server sent no certificate
+func newTLSCertificate(state *tls.ConnectionState, established time.Time) *TLSCertificate {
+	if len(state.PeerCertificates) == 0 {
+		return nil
+	}
+	leaf := state.PeerCertificates[0]
+
+	spki := sha256.Sum256(leaf.RawSubjectPublicKeyInfo)
+	cert := &TLSCertificate{
+		Subject:      leaf.Subject.String(),
+		DNSNames:     leaf.DNSNames,
+		Issuer:       leaf.Issuer.String(),
+		SerialNumber: leaf.SerialNumber.Text(16),
+		NotBefore:    leaf.NotBefore,
+		NotAfter:     leaf.NotAfter,
+		ExpiresIn:    leaf.NotAfter.Sub(established),
+		SPKISHA256:   base64.StdEncoding.EncodeToString(spki[:]),
+		ChainLength:  len(state.PeerCertificates),
+		OCSPStapled:  len(state.OCSPResponse) != 0,
+	}
+	for _, ip := range leaf.IPAddresses {
+		cert.IPAddresses = append(cert.IPAddresses, ip.String())
+	}
+	cert.KeyType, cert.KeySize = publicKeyInfo(leaf)
+	return cert
+}
+
+func publicKeyInfo(cert *x509.Certificate) (string, int) {
+	switch key := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return "RSA", key.N.BitLen()
+	case *ecdsa.PublicKey:
+		return "ECDSA", key.Curve.Params().BitSize
+	case ed25519.PublicKey:
+		return "Ed25519", 8 * len(key)
+	default:
+		return cert.PublicKeyAlgorithm.String(), 0
+	}
+}
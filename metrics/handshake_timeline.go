@@ -0,0 +1,93 @@
+package metrics
+
+import (
+	"sort"
+	"time"
+)
+
+// HandshakeEventName names a step of the TLS or QUIC handshake in the handshake timeline
+type HandshakeEventName string
+
+const (
+	// HandshakeEventTLSClientHelloSent is the first handshake record written to the TCP connection and
+	// HandshakeEventTLSServerHelloReceived the first record read from it, which carries the ServerHello
+	HandshakeEventTLSClientHelloSent     HandshakeEventName = "tls_client_hello_sent"
+	HandshakeEventTLSServerHelloReceived HandshakeEventName = "tls_server_hello_received"
+	// HandshakeEventCertificateVerified is when the certificate chain of the server was verified, including the
+	// usage profile and DANE, it is used by TLS and QUIC
+	HandshakeEventCertificateVerified HandshakeEventName = "certificate_verified"
+	// HandshakeEventTLSFinished is when the handshake completed with the Finished message of the client
+	HandshakeEventTLSFinished HandshakeEventName = "tls_finished"
+
+	HandshakeEventQUICInitialSent       HandshakeEventName = "quic_initial_sent"
+	HandshakeEventQUICInitialReceived   HandshakeEventName = "quic_initial_received"
+	HandshakeEventQUICHandshakeReceived HandshakeEventName = "quic_handshake_received"
+	// HandshakeEventQUICHandshakeKeys and HandshakeEventQUIC1RTTKeys are when TLS installed the keys of the
+	// encryption level, i.e. after processing the ServerHello and the server Finished
+	HandshakeEventQUICHandshakeKeys HandshakeEventName = "quic_handshake_keys"
+	HandshakeEventQUIC1RTTKeys      HandshakeEventName = "quic_1rtt_keys"
+	// HandshakeEventQUICHandshakeDone is the arrival of the HANDSHAKE_DONE frame, which confirms the handshake
+	HandshakeEventQUICHandshakeDone HandshakeEventName = "quic_handshake_done"
+	// HandshakeEventQUIC0RTTSent is the first 0-RTT packet and HandshakeEventQUIC0RTTAcknowledged the first
+	// acknowledgement of one, which is missing if the server rejected 0-RTT
+	HandshakeEventQUIC0RTTSent         HandshakeEventName = "quic_0rtt_sent"
+	HandshakeEventQUIC0RTTAcknowledged HandshakeEventName = "quic_0rtt_acknowledged"
+)
+
+// HandshakeEvent is a step of the handshake, Time is relative to the start of the exchange
+type HandshakeEvent struct {
+	Name HandshakeEventName `json:"name"`
+	Time time.Duration      `json:"time"`
+}
+
+// HandshakeEvent records that the handshake reached the step name now, only the first time of every step is kept
+func (c *Collector) HandshakeEvent(name HandshakeEventName) {
+	c.HandshakeEventAt(name, time.Now())
+}
+
+// HandshakeEventAt records that the handshake reached the step name at t, it is used by the QUIC tracer which
+// passes its events on at the end of the exchange
+func (c *Collector) HandshakeEventAt(name HandshakeEventName, t time.Time) {
+	c.handshakeMutex.Lock()
+	defer c.handshakeMutex.Unlock()
+	if _, ok := c.handshakeEvents[name]; ok {
+		return
+	}
+	if c.handshakeEvents == nil {
+		c.handshakeEvents = make(map[HandshakeEventName]time.Time)
+	}
+	c.handshakeEvents[name] = t
+}
+
+// adoptHandshakeEvents copies the events of a connection attempt
+func (c *Collector) adoptHandshakeEvents(attempt *Collector) {
+	attempt.handshakeMutex.Lock()
+	defer attempt.handshakeMutex.Unlock()
+	for name, t := range attempt.handshakeEvents {
+		c.HandshakeEventAt(name, t)
+	}
+}
+
+// transformHandshakeTimeline orders the events by time, the timeline is left out on reused connections as their
+// handshake belongs to an earlier exchange
+func (r *Result) transformHandshakeTimeline() {
+	if r.collector.connectionReused {
+		return
+	}
+
+	r.collector.handshakeMutex.Lock()
+	defer r.collector.handshakeMutex.Unlock()
+	for name, t := range r.collector.handshakeEvents {
+		r.HandshakeTimeline = append(r.HandshakeTimeline, HandshakeEvent{
+			Name: name,
+			Time: t.Sub(r.collector.startTime),
+		})
+	}
+	sort.Slice(r.HandshakeTimeline, func(i, j int) bool {
+		a, b := r.HandshakeTimeline[i], r.HandshakeTimeline[j]
+		if a.Time != b.Time {
+			return a.Time < b.Time
+		}
+		return a.Name < b.Name
+	})
+}
@@ -0,0 +1,158 @@
+package metrics
+
+import (
+	"math"
+	"math/bits"
+	"time"
+)
+
+// subBucketBits sets the precision of the histogram: every power of two is split into 2^(subBucketBits-1)
+// linear sub-buckets, which bounds the relative error of a recorded value to 1/2^(subBucketBits-1), about 1.6%
+const subBucketBits = 7
+
+const subBucketHalfCount = 1 << (subBucketBits - 1)
+
+// Histogram is a log-linear (HDR-style) histogram of durations. It keeps a fixed number of buckets instead of
+// every sample, and histograms filled by different workers can be merged. It is not safe for concurrent use.
+type Histogram struct {
+	counts []uint64
+	count  uint64
+	min    time.Duration
+	max    time.Duration
+
+	// mean and m2 are maintained with Welford's algorithm so that the standard deviation is exact
+	mean float64
+	m2   float64
+}
+
+func NewHistogram() *Histogram {
+	return &Histogram{}
+}
+
+func bucketIndex(value uint64) int {
+	if value < 2*subBucketHalfCount {
+		return int(value)
+	}
+	shift := bits.Len64(value) - subBucketBits
+	return shift*subBucketHalfCount + int(value>>uint(shift))
+}
+
+// bucketUpperBound returns the highest value that is recorded into the bucket at index
+func bucketUpperBound(index int) uint64 {
+	if index < 2*subBucketHalfCount {
+		return uint64(index)
+	}
+	shift := index/subBucketHalfCount - 1
+	subBucket := uint64(index - shift*subBucketHalfCount)
+	return (subBucket+1)<<uint(shift) - 1
+}
+
+// Record adds a single duration, negative durations are recorded as zero
+func (h *Histogram) Record(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+
+	index := bucketIndex(uint64(d))
+	if index >= len(h.counts) {
+		counts := make([]uint64, index+1)
+		copy(counts, h.counts)
+		h.counts = counts
+	}
+	h.counts[index]++
+
+	if h.count == 0 || d < h.min {
+		h.min = d
+	}
+	if h.count == 0 || d > h.max {
+		h.max = d
+	}
+
+	h.count++
+	delta := float64(d) - h.mean
+	h.mean += delta / float64(h.count)
+	h.m2 += delta * (float64(d) - h.mean)
+}
+
+// Merge adds all values recorded by other
+func (h *Histogram) Merge(other *Histogram) {
+	if other == nil || other.count == 0 {
+		return
+	}
+	if len(other.counts) > len(h.counts) {
+		counts := make([]uint64, len(other.counts))
+		copy(counts, h.counts)
+		h.counts = counts
+	}
+	for i, count := range other.counts {
+		h.counts[i] += count
+	}
+
+	if h.count == 0 || other.min < h.min {
+		h.min = other.min
+	}
+	if h.count == 0 || other.max > h.max {
+		h.max = other.max
+	}
+
+	count := h.count + other.count
+	delta := other.mean - h.mean
+	h.m2 += other.m2 + delta*delta*float64(h.count)*float64(other.count)/float64(count)
+	h.mean += delta * float64(other.count) / float64(count)
+	h.count = count
+}
+
+func (h *Histogram) Count() uint64 {
+	return h.count
+}
+
+func (h *Histogram) Min() time.Duration {
+	return h.min
+}
+
+func (h *Histogram) Max() time.Duration {
+	return h.max
+}
+
+func (h *Histogram) Mean() time.Duration {
+	return time.Duration(h.mean)
+}
+
+// StdDev returns the population standard deviation
+func (h *Histogram) StdDev() time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	return time.Duration(math.Sqrt(h.m2 / float64(h.count)))
+}
+
+// Percentile returns the value below or at which p percent of the recorded values fall, within the
+// precision of the buckets
+func (h *Histogram) Percentile(p float64) time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	if p <= 0 {
+		return h.min
+	}
+	if p >= 100 {
+		return h.max
+	}
+
+	rank := uint64(math.Ceil(p / 100 * float64(h.count)))
+	var seen uint64
+	for index, count := range h.counts {
+		seen += count
+		if seen >= rank {
+			value := time.Duration(bucketUpperBound(index))
+			if value < h.min {
+				return h.min
+			}
+			if value > h.max {
+				return h.max
+			}
+			return value
+		}
+	}
+	return h.max
+}
@@ -0,0 +1,148 @@
+package metrics
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestBucketBoundaries(t *testing.T) {
+	tests := []struct {
+		value      uint64
+		index      int
+		upperBound uint64
+	}{
+		{0, 0, 0},
+		{1, 1, 1},
+		{127, 127, 127},
+		{128, 128, 129},
+		{129, 128, 129},
+		{130, 129, 131},
+		{255, 191, 255},
+		{256, 192, 259},
+		{259, 192, 259},
+		{260, 193, 263},
+		{1 << 20, 14*subBucketHalfCount + 64, 1<<20 + 1<<14 - 1},
+	}
+	for _, tt := range tests {
+		index := bucketIndex(tt.value)
+		if index != tt.index {
+			t.Errorf("bucketIndex(%d) = %d, want %d", tt.value, index, tt.index)
+		}
+		if upperBound := bucketUpperBound(index); upperBound != tt.upperBound {
+			t.Errorf("bucketUpperBound(%d) = %d, want %d", index, upperBound, tt.upperBound)
+		}
+	}
+}
+
+func TestBucketsCoverAllValues(t *testing.T) {
+	values := []uint64{0, 1, 63, 64, 127, 128, 1000, 65535, 65536, uint64(time.Second), uint64(time.Hour), math.MaxInt64}
+	for value := uint64(0); value < 5000; value++ {
+		values = append(values, value)
+	}
+
+	for _, value := range values {
+		index := bucketIndex(value)
+		upperBound := bucketUpperBound(index)
+		if upperBound < value {
+			t.Fatalf("value %d is above the upper bound %d of its bucket %d", value, upperBound, index)
+		}
+		if index > 0 && bucketUpperBound(index-1) >= value {
+			t.Fatalf("value %d is not above the upper bound %d of the previous bucket", value, bucketUpperBound(index-1))
+		}
+		if value > 0 && float64(upperBound-value)/float64(value) >= 1.0/subBucketHalfCount {
+			t.Fatalf("bucket %d of value %d has a relative error of 1/%d or more", index, value, subBucketHalfCount)
+		}
+	}
+}
+
+func TestHistogramStatistics(t *testing.T) {
+	h := NewHistogram()
+	for _, d := range []time.Duration{2, 4, 4, 4, 5, 5, 7, 9} {
+		h.Record(d * time.Millisecond)
+	}
+	h.Record(-time.Second)
+
+	tests := []struct {
+		name string
+		got  time.Duration
+		want time.Duration
+	}{
+		{"min", h.Min(), 0},
+		{"max", h.Max(), 9 * time.Millisecond},
+		{"mean", h.Mean(), 40 * time.Millisecond / 9},
+		{"p0", h.Percentile(0), 0},
+		{"p100", h.Percentile(100), 9 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s = %s, want %s", tt.name, tt.got, tt.want)
+		}
+	}
+	if h.Count() != 9 {
+		t.Errorf("Count() = %d, want 9", h.Count())
+	}
+	if p50 := h.Percentile(50); p50 < 4*time.Millisecond || p50 > 4*time.Millisecond+4*time.Millisecond/subBucketHalfCount {
+		t.Errorf("Percentile(50) = %s, want 4ms within the bucket precision", p50)
+	}
+}
+
+func TestHistogramMerge(t *testing.T) {
+	values := make([]time.Duration, 0, 1000)
+	for i := 0; i < 1000; i++ {
+		values = append(values, time.Duration(i*i%7919)*time.Microsecond)
+	}
+
+	tests := []struct {
+		name  string
+		split int
+	}{
+		{"empty first", 0},
+		{"single first", 1},
+		{"halves", 500},
+		{"empty second", len(values)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			all, first, second := NewHistogram(), NewHistogram(), NewHistogram()
+			for i, value := range values {
+				all.Record(value)
+				if i < tt.split {
+					first.Record(value)
+				} else {
+					second.Record(value)
+				}
+			}
+			first.Merge(second)
+			first.Merge(nil)
+
+			if first.Count() != all.Count() || first.Min() != all.Min() || first.Max() != all.Max() {
+				t.Errorf("merged count, min, max = %d, %s, %s, want %d, %s, %s",
+					first.Count(), first.Min(), first.Max(), all.Count(), all.Min(), all.Max())
+			}
+			if diff := first.Mean() - all.Mean(); diff < -1 || diff > 1 {
+				t.Errorf("merged Mean() = %s, want %s", first.Mean(), all.Mean())
+			}
+			if diff := first.StdDev() - all.StdDev(); diff < -1 || diff > 1 {
+				t.Errorf("merged StdDev() = %s, want %s", first.StdDev(), all.StdDev())
+			}
+			for _, p := range []float64{1, 50, 90, 99, 99.9} {
+				if first.Percentile(p) != all.Percentile(p) {
+					t.Errorf("merged Percentile(%v) = %s, want %s", p, first.Percentile(p), all.Percentile(p))
+				}
+			}
+		})
+	}
+}
+
+func TestHistogramStdDev(t *testing.T) {
+	values := []time.Duration{2, 4, 4, 4, 5, 5, 7, 9}
+	h := NewHistogram()
+	for _, value := range values {
+		h.Record(value * time.Second)
+	}
+	// the population standard deviation of the values is exactly 2
+	if h.StdDev() != 2*time.Second {
+		t.Errorf("StdDev() = %s, want 2s", h.StdDev())
+	}
+}
@@ -1,19 +1,34 @@
 package metrics
 
 import (
+	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
+	"fmt"
+	"github.com/lucas-clemente/quic-go"
+	"github.com/mgranderath/dnsperf/doqerr"
 	"github.com/mgranderath/dnsperf/qerr"
+	"github.com/mgranderath/dnsperf/terr"
 	"github.com/miekg/dns"
-	"log"
+	"sync"
 	"time"
 )
 
 type Collector struct {
 	startTime time.Time
 
+	bootstrapStartTime time.Time
+	bootstrapDoneTime  time.Time
+	bootstrapSource    *string
+	bootstrapFailed    bool
+
 	udpSocketSetupStartTime time.Time
 	udpSocketSetupDoneTime  time.Time
+	udpQuerySendTime        time.Time
+	udpQueryReceiveTime     time.Time
+	udpQuerySize            *int
+	udpResponseSize         *int
+	tcpFallback             bool
 
 	tcpHandshakeStartTime time.Time
 	tcpHandshakeDoneTime  time.Time
@@ -22,6 +37,20 @@ type Collector struct {
 	tlsHandshakeDoneTime  time.Time
 	tlsVersion            *uint16
 	tlsError              *x509.InvalidReason
+	tlsAlert              *terr.ErrorCode
+	tlsState              *tls.ConnectionState
+	tlsStateTime          time.Time
+	tlsProfile            *string
+	tlsAuthentication     *TLSAuthentication
+	tlsaLookupStartTime   time.Time
+	tlsaLookupDoneTime    time.Time
+	daneResult            *DANEResult
+	daneRecord            *string
+
+	// session tickets may arrive on another goroutine while or after the exchange finished
+	ticketMutex    sync.Mutex
+	ticketReceived bool
+	ticketLifetime *time.Duration
 
 	quicHandshakeStartTime time.Time
 	quicHandshakeDoneTime  time.Time
@@ -29,15 +58,45 @@ type Collector struct {
 	quicError              *qerr.ErrorCode
 	quicNegotiatedProtocol *string
 	quicUsed0RTT		bool
+	quicStats              *QUICStats
+	quicConnectionInfo     *quic.ConnectionInfo
+	doqFramingMismatch     bool
+	doqError               *doqerr.ErrorCode
+	doqErrorSource         *doqerr.Source
 
 	querySendTime    time.Time
 	queryReceiveTime time.Time
+	querySize        *int
+	responseSize     *int
+
+	dnscryptCertFetchStartTime time.Time
+	dnscryptCertFetchDoneTime  time.Time
+	dnscryptVersion            *uint16
+	dnscryptEncryptionOverhead *time.Duration
 
 	httpVersion *string
+	httpStatus  *int
+
+	connectionReused   bool
+	connectionRedialed bool
+
+	remoteAddress      *string
+	dialAttemptsFailed int
+
+	cancelled bool
+	timedOut  bool
 
 	endTime time.Time
 
+	// handshakeMutex guards the handshake events, TLS and QUIC report them from the goroutines of the handshake
+	handshakeMutex  sync.Mutex
+	handshakeEvents map[HandshakeEventName]time.Time
+
+	// qLogMutex guards the qlog fields, qlog output is written by quic-go while the result is read
+	qLogMutex    sync.Mutex
 	qLogMessages []map[string]interface{}
+	qLogFile     *string
+	qLogError    error
 }
 
 func NewCollector() *Collector {
@@ -72,6 +131,21 @@ func (c *Collector) ExchangeStarted() {
 	c.startTime = time.Now()
 }
 
+func (c *Collector) BootstrapStart() {
+	c.bootstrapStartTime = time.Now()
+}
+
+// BootstrapDone records that the host of the upstream was resolved, source is the bootstrap upstream that answered
+func (c *Collector) BootstrapDone(source string) {
+	c.bootstrapDoneTime = time.Now()
+	c.bootstrapSource = &source
+}
+
+func (c *Collector) BootstrapFailed() {
+	c.bootstrapDoneTime = time.Now()
+	c.bootstrapFailed = true
+}
+
 func (c *Collector) UDPSocketSetupStart() {
 	c.udpSocketSetupStartTime = time.Now()
 }
@@ -80,6 +154,20 @@ func (c *Collector) UDPSocketSetupFinished() {
 	c.udpSocketSetupDoneTime = time.Now()
 }
 
+// TCPFallback moves the timing and sizes of the query sent over UDP aside, so that the following TCP query is
+// recorded on its own
+func (c *Collector) TCPFallback() {
+	c.tcpFallback = true
+	c.udpQuerySendTime = c.querySendTime
+	c.udpQueryReceiveTime = c.queryReceiveTime
+	c.udpQuerySize = c.querySize
+	c.udpResponseSize = c.responseSize
+	c.querySendTime = time.Time{}
+	c.queryReceiveTime = time.Time{}
+	c.querySize = nil
+	c.responseSize = nil
+}
+
 func (c *Collector) TCPHandshakeStart() {
 	c.tcpHandshakeStartTime = time.Now()
 }
@@ -97,10 +185,49 @@ func (c *Collector) TLSHandshakeFinished(version uint16) {
 	c.tlsVersion = &version
 }
 
+// TLSConnectionState records the negotiated parameters and the certificate of an established TLS connection
+func (c *Collector) TLSConnectionState(state tls.ConnectionState) {
+	c.tlsState = &state
+	c.tlsStateTime = time.Now()
+}
+
+// TLSAuthentication records the usage profile the server was authenticated with and the result
+func (c *Collector) TLSAuthentication(profile string, authentication TLSAuthentication) {
+	c.tlsProfile = &profile
+	c.tlsAuthentication = &authentication
+}
+
+func (c *Collector) TLSALookupStart() {
+	c.tlsaLookupStartTime = time.Now()
+}
+
+func (c *Collector) TLSALookupDone() {
+	c.tlsaLookupDoneTime = time.Now()
+}
+
+// DANE records the result of matching the TLSA records, record is the usage, selector and matching type of the
+// record that matched
+func (c *Collector) DANE(result DANEResult, record *string) {
+	c.daneResult = &result
+	c.daneRecord = record
+}
+
+// TLSSessionTicket records that the server sent a session ticket, lifetime is its lifetime hint if known
+func (c *Collector) TLSSessionTicket(lifetime *time.Duration) {
+	c.ticketMutex.Lock()
+	defer c.ticketMutex.Unlock()
+	c.ticketReceived = true
+	c.ticketLifetime = lifetime
+}
+
 func (c *Collector) TLSError(err x509.InvalidReason) {
 	c.tlsError = &err
 }
 
+func (c *Collector) TLSAlert(alert terr.ErrorCode) {
+	c.tlsAlert = &alert
+}
+
 func (c *Collector) QUICError(err qerr.ErrorCode) {
 	c.quicError = &err
 }
@@ -125,6 +252,31 @@ func (c *Collector) QUICUsed0RTT(used0RTT bool) {
 	c.quicUsed0RTT = used0RTT
 }
 
+// QUICConnectionInfo records the state of the QUIC connection including its version and whether 0-RTT was used,
+// later calls replace the state of earlier ones
+func (c *Collector) QUICConnectionInfo(info quic.ConnectionInfo) {
+	version := uint64(info.Version)
+	c.quicVersion = &version
+	if info.HandshakeComplete {
+		c.quicUsed0RTT = info.Used0RTT
+	}
+	c.quicConnectionInfo = &info
+}
+
+// QUICStats records the statistics of the QUIC connection, later calls replace the statistics of earlier ones
+func (c *Collector) QUICStats(stats QUICStats) {
+	c.quicStats = &stats
+}
+
+func (c *Collector) DoQError(code doqerr.ErrorCode, source doqerr.Source) {
+	c.doqError = &code
+	c.doqErrorSource = &source
+}
+
+func (c *Collector) DoQFramingMismatch() {
+	c.doqFramingMismatch = true
+}
+
 func (c *Collector) TLSVersion(tlsVersion uint16) {
 	c.tlsVersion = &tlsVersion
 }
@@ -137,19 +289,146 @@ func (c *Collector) QueryReceive() {
 	c.queryReceiveTime = time.Now()
 }
 
+func (c *Collector) QuerySize(size int) {
+	c.querySize = &size
+}
+
+func (c *Collector) ResponseSize(size int) {
+	c.responseSize = &size
+}
+
+func (c *Collector) DNSCryptCertFetchStart() {
+	c.dnscryptCertFetchStartTime = time.Now()
+}
+
+func (c *Collector) DNSCryptCertFetchDone(esVersion uint16) {
+	c.dnscryptCertFetchDoneTime = time.Now()
+	c.dnscryptVersion = &esVersion
+}
+
+func (c *Collector) DNSCryptVersion(esVersion uint16) {
+	c.dnscryptVersion = &esVersion
+}
+
+// DNSCryptEncryptionOverhead adds time spent encrypting a query or decrypting a response
+func (c *Collector) DNSCryptEncryptionOverhead(duration time.Duration) {
+	if c.dnscryptEncryptionOverhead == nil {
+		c.dnscryptEncryptionOverhead = new(time.Duration)
+	}
+	*c.dnscryptEncryptionOverhead += duration
+}
+
 func (c *Collector) HTTPVersion(version string) {
 	c.httpVersion = &version
 }
 
+func (c *Collector) HTTPStatus(status int) {
+	c.httpStatus = &status
+}
+
+func (c *Collector) RemoteAddress(address string) {
+	c.remoteAddress = &address
+}
+
+func (c *Collector) DialAttemptsFailed(count int) {
+	c.dialAttemptsFailed += count
+}
+
+// AdoptConnectionAttempt takes over the connection setup recorded by attempt, which raced other connection attempts
+// with a collector of its own
+func (c *Collector) AdoptConnectionAttempt(attempt *Collector) {
+	if !attempt.udpSocketSetupStartTime.IsZero() {
+		c.udpSocketSetupStartTime = attempt.udpSocketSetupStartTime
+		c.udpSocketSetupDoneTime = attempt.udpSocketSetupDoneTime
+	}
+	if !attempt.tcpHandshakeStartTime.IsZero() {
+		c.tcpHandshakeStartTime = attempt.tcpHandshakeStartTime
+		c.tcpHandshakeDoneTime = attempt.tcpHandshakeDoneTime
+	}
+	if !attempt.quicHandshakeStartTime.IsZero() {
+		c.quicHandshakeStartTime = attempt.quicHandshakeStartTime
+		c.quicHandshakeDoneTime = attempt.quicHandshakeDoneTime
+	}
+	if attempt.tlsVersion != nil {
+		c.tlsVersion = attempt.tlsVersion
+	}
+	if attempt.tlsState != nil {
+		c.tlsState = attempt.tlsState
+		c.tlsStateTime = attempt.tlsStateTime
+	}
+	if attempt.tlsAuthentication != nil {
+		c.tlsProfile = attempt.tlsProfile
+		c.tlsAuthentication = attempt.tlsAuthentication
+	}
+	if attempt.daneResult != nil {
+		c.daneResult = attempt.daneResult
+		c.daneRecord = attempt.daneRecord
+	}
+	if attempt.tlsAlert != nil {
+		c.tlsAlert = attempt.tlsAlert
+	}
+	if attempt.quicVersion != nil {
+		c.quicVersion = attempt.quicVersion
+	}
+	if attempt.quicError != nil {
+		c.quicError = attempt.quicError
+	}
+	if attempt.quicNegotiatedProtocol != nil {
+		c.quicNegotiatedProtocol = attempt.quicNegotiatedProtocol
+	}
+	c.adoptHandshakeEvents(attempt)
+}
+
+func (c *Collector) ConnectionReused() {
+	c.connectionReused = true
+}
+
+// ConnectionRedialed records that the connection kept from an earlier exchange was closed or unusable and the
+// exchange dialed a new one
+func (c *Collector) ConnectionRedialed() {
+	c.connectionRedialed = true
+}
+
+func (c *Collector) Cancelled() {
+	c.cancelled = true
+}
+
+func (c *Collector) TimedOut() {
+	c.timedOut = true
+}
+
 func (c *Collector) ExchangeFinished() {
 	c.endTime = time.Now()
 }
 
+// QLogMessage records a qlog record, a record that cannot be parsed is recorded as qlog error instead
 func (c *Collector) QLogMessage(message []byte) {
 	m := make(map[string]interface{})
 	err := json.Unmarshal(message, &m)
+
+	c.qLogMutex.Lock()
+	defer c.qLogMutex.Unlock()
 	if err != nil {
-		log.Panic(err)
+		if c.qLogError == nil {
+			c.qLogError = fmt.Errorf("malformed qlog record: %w", err)
+		}
+		return
 	}
 	c.qLogMessages = append(c.qLogMessages, m)
 }
+
+// QLogFile records the path of the file the qlog of the connection is written to
+func (c *Collector) QLogFile(path string) {
+	c.qLogMutex.Lock()
+	defer c.qLogMutex.Unlock()
+	c.qLogFile = &path
+}
+
+// QLogError records why the qlog could not be written, only the first error is kept
+func (c *Collector) QLogError(err error) {
+	c.qLogMutex.Lock()
+	defer c.qLogMutex.Unlock()
+	if c.qLogError == nil {
+		c.qLogError = err
+	}
+}
@@ -0,0 +1,121 @@
+package metrics
+
+import (
+	"crypto/x509"
+	"errors"
+	"github.com/miekg/dns"
+	"strconv"
+	"syscall"
+)
+
+type OutcomeClass string
+
+const (
+	OutcomeRcode               OutcomeClass = "rcode"
+	OutcomeTruncated           OutcomeClass = "truncated"
+	OutcomeIDMismatch          OutcomeClass = "id_mismatch"
+	OutcomeTimeout             OutcomeClass = "timeout"
+	OutcomeCancelled           OutcomeClass = "cancelled"
+	OutcomeBootstrapError      OutcomeClass = "bootstrap_error"
+	OutcomeConnectionRefused   OutcomeClass = "connection_refused"
+	OutcomeTLSCertificateError OutcomeClass = "tls_certificate_error"
+	OutcomeTLSAlert            OutcomeClass = "tls_alert"
+	OutcomeTLSAuthentication   OutcomeClass = "tls_authentication_failed"
+	OutcomeQUICTransportError  OutcomeClass = "quic_transport_error"
+	OutcomeDoQFramingMismatch  OutcomeClass = "doq_framing_mismatch"
+	OutcomeDoQError            OutcomeClass = "doq_error"
+	OutcomeHTTPStatusError     OutcomeClass = "http_status_error"
+	OutcomeError               OutcomeClass = "error"
+)
+
+// Outcome classifies how an exchange ended, Detail refines the class (e.g. the rcode or the TLS alert)
+type Outcome struct {
+	Class  OutcomeClass `json:"class"`
+	Detail string       `json:"detail,omitempty"`
+}
+
+func (o Outcome) String() string {
+	if o.Detail == "" {
+		return string(o.Class)
+	}
+	return string(o.Class) + ":" + o.Detail
+}
+
+var x509ReasonNames = map[x509.InvalidReason]string{
+	x509.NotAuthorizedToSign:           "not_authorized_to_sign",
+	x509.Expired:                       "expired",
+	x509.CANotAuthorizedForThisName:    "ca_not_authorized_for_this_name",
+	x509.TooManyIntermediates:          "too_many_intermediates",
+	x509.IncompatibleUsage:             "incompatible_usage",
+	x509.NameMismatch:                  "name_mismatch",
+	x509.NameConstraintsWithoutSANs:    "name_constraints_without_sans",
+	x509.UnconstrainedName:             "unconstrained_name",
+	x509.TooManyConstraints:            "too_many_constraints",
+	x509.CANotAuthorizedForExtKeyUsage: "ca_not_authorized_for_ext_key_usage",
+}
+
+func certificateErrorDetail(err error, collector *Collector) (string, bool) {
+	if collector.tlsError != nil {
+		if name, ok := x509ReasonNames[*collector.tlsError]; ok {
+			return name, true
+		}
+		return strconv.Itoa(int(*collector.tlsError)), true
+	}
+	if errors.As(err, &x509.UnknownAuthorityError{}) {
+		return "unknown_authority", true
+	}
+	if errors.As(err, &x509.HostnameError{}) {
+		return "hostname_mismatch", true
+	}
+	return "", false
+}
+
+func classifyOutcome(response *dns.Msg, err error, collector *Collector) Outcome {
+	if err == nil {
+		if response == nil {
+			return Outcome{Class: OutcomeError}
+		}
+		if response.Truncated {
+			return Outcome{Class: OutcomeTruncated}
+		}
+		return Outcome{Class: OutcomeRcode, Detail: dns.RcodeToString[response.Rcode]}
+	}
+
+	switch {
+	case collector.cancelled:
+		return Outcome{Class: OutcomeCancelled}
+	case collector.timedOut:
+		return Outcome{Class: OutcomeTimeout}
+	case collector.bootstrapFailed:
+		return Outcome{Class: OutcomeBootstrapError}
+	case errors.Is(err, dns.ErrId):
+		return Outcome{Class: OutcomeIDMismatch}
+	case collector.tlsAuthentication != nil && *collector.tlsAuthentication == TLSAuthenticationFailed &&
+		collector.tlsProfile != nil && *collector.tlsProfile == "strict":
+		// the detail is the reason the certificate was rejected, empty if it did not match the SPKI pins
+		detail, _ := certificateErrorDetail(err, collector)
+		return Outcome{Class: OutcomeTLSAuthentication, Detail: detail}
+	case collector.tlsAlert != nil:
+		return Outcome{Class: OutcomeTLSAlert, Detail: collector.tlsAlert.String()}
+	case collector.quicError != nil:
+		return Outcome{Class: OutcomeQUICTransportError, Detail: collector.quicError.String()}
+	case collector.doqError != nil:
+		return Outcome{Class: OutcomeDoQError, Detail: collector.doqError.String()}
+	case collector.doqFramingMismatch:
+		// the detail is the negotiated ALPN, which selected the expected framing
+		detail := ""
+		if collector.quicNegotiatedProtocol != nil {
+			detail = *collector.quicNegotiatedProtocol
+		}
+		return Outcome{Class: OutcomeDoQFramingMismatch, Detail: detail}
+	case collector.httpStatus != nil && *collector.httpStatus != 200:
+		return Outcome{Class: OutcomeHTTPStatusError, Detail: strconv.Itoa(*collector.httpStatus)}
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return Outcome{Class: OutcomeConnectionRefused}
+	}
+
+	if detail, ok := certificateErrorDetail(err, collector); ok {
+		return Outcome{Class: OutcomeTLSCertificateError, Detail: detail}
+	}
+	return Outcome{Class: OutcomeError}
+}
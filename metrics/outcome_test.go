@@ -0,0 +1,68 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/mgranderath/dnsperf/doqerr"
+	"github.com/mgranderath/dnsperf/qerr"
+	"github.com/mgranderath/dnsperf/terr"
+	"github.com/miekg/dns"
+	"os"
+	"testing"
+)
+
+func TestClassifyOutcome(t *testing.T) {
+	response := func(rcode int) *dns.Msg {
+		m := new(dns.Msg)
+		m.SetQuestion("example.org.", dns.TypeA)
+		m.Rcode = rcode
+		return m
+	}
+	truncated := response(dns.RcodeSuccess)
+	truncated.Truncated = true
+
+	tests := []struct {
+		name     string
+		response *dns.Msg
+		err      error
+		collect  func(c *Collector)
+		want     Outcome
+	}{
+		{"noerror", response(dns.RcodeSuccess), nil, nil, Outcome{Class: OutcomeRcode, Detail: "NOERROR"}},
+		{"nxdomain", response(dns.RcodeNameError), nil, nil, Outcome{Class: OutcomeRcode, Detail: "NXDOMAIN"}},
+		{"servfail", response(dns.RcodeServerFailure), nil, nil, Outcome{Class: OutcomeRcode, Detail: "SERVFAIL"}},
+		{"truncated", truncated, nil, nil, Outcome{Class: OutcomeTruncated}},
+		{"no response", nil, nil, nil, Outcome{Class: OutcomeError}},
+		{"id mismatch", response(dns.RcodeSuccess), dns.ErrId, nil, Outcome{Class: OutcomeIDMismatch}},
+		{"wrapped id mismatch", nil, fmt.Errorf("exchange: %w", dns.ErrId), nil, Outcome{Class: OutcomeIDMismatch}},
+		{"timeout", nil, os.ErrDeadlineExceeded, func(c *Collector) { c.TimedOut() }, Outcome{Class: OutcomeTimeout}},
+		{"cancelled", nil, context.Canceled, func(c *Collector) { c.Cancelled() }, Outcome{Class: OutcomeCancelled}},
+		{"tls alert", nil, errors.New("remote error: tls: protocol version not supported"), func(c *Collector) {
+			c.TLSAlert(terr.ProtocolVersion)
+		}, Outcome{Class: OutcomeTLSAlert, Detail: "protocol_version"}},
+		{"quic transport error", nil, errors.New("PROTOCOL_VIOLATION"), func(c *Collector) {
+			c.QUICError(qerr.ProtocolViolation)
+		}, Outcome{Class: OutcomeQUICTransportError, Detail: "PROTOCOL_VIOLATION"}},
+		{"doq error", nil, errors.New("stream reset"), func(c *Collector) {
+			c.DoQError(doqerr.ProtocolError, doqerr.SourceStream)
+		}, Outcome{Class: OutcomeDoQError, Detail: "DOQ_PROTOCOL_ERROR"}},
+		// the timeout is the cause, alerts or errors recorded before it are consequences of it
+		{"timeout before tls alert", nil, os.ErrDeadlineExceeded, func(c *Collector) {
+			c.TLSAlert(terr.InternalError)
+			c.TimedOut()
+		}, Outcome{Class: OutcomeTimeout}},
+		{"other error", nil, errors.New("failed"), nil, Outcome{Class: OutcomeError}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			collector := NewCollector()
+			if tt.collect != nil {
+				tt.collect(collector)
+			}
+			if got := classifyOutcome(tt.response, tt.err, collector); got != tt.want {
+				t.Errorf("classifyOutcome() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package metrics
+
+import (
+	"github.com/lucas-clemente/quic-go"
+	"time"
+)
+
+// QUICStats describes a QUIC connection as seen by the tracer of the client. The statistics cover the connection
+// from its first packet until the end of the exchange, on reused connections they include the earlier exchanges.
+type QUICStats struct {
+	RetryReceived               bool `json:"retry_received"`
+	VersionNegotiationPerformed bool `json:"version_negotiation_performed"`
+
+	// Initial, Handshake, ZeroRTT and OneRTT count the packets of each encryption level, a level is left out
+	// if no packet of it was sent or received
+	Initial   *QUICPacketStats `json:"initial,omitempty"`
+	Handshake *QUICPacketStats `json:"handshake,omitempty"`
+	ZeroRTT   *QUICPacketStats `json:"0rtt,omitempty"`
+	OneRTT    *QUICPacketStats `json:"1rtt,omitempty"`
+
+	// PacketsLost counts the packets declared lost, PTOCount the probe timeouts that expired
+	PacketsLost int    `json:"packets_lost"`
+	PTOCount    uint32 `json:"pto_count"`
+
+	MinRTT      *time.Duration `json:"min_rtt,omitempty"`
+	SmoothedRTT *time.Duration `json:"smoothed_rtt,omitempty"`
+
+	// FirstServerPacketDelay is the time from the first Initial the client sent until the first packet of the server
+	// arrived, which includes Retry and Version Negotiation packets
+	FirstServerPacketDelay *time.Duration `json:"first_server_packet_delay,omitempty"`
+}
+
+// QUICPacketStats counts the packets of one encryption level, sizes include the packet headers
+type QUICPacketStats struct {
+	PacketsSent     int    `json:"packets_sent"`
+	BytesSent       uint64 `json:"bytes_sent"`
+	PacketsReceived int    `json:"packets_received"`
+	BytesReceived   uint64 `json:"bytes_received"`
+}
+
+// QUICConnection describes the state of the QUIC connection at the end of the exchange as reported by quic-go
+type QUICConnection struct {
+	CongestionWindow uint64 `json:"congestion_window"`
+	BytesInFlight    uint64 `json:"bytes_in_flight"`
+
+	// ZeroRTTRejected is set if the client sent 0-RTT data that the server rejected, QUICUsed0RTT is set if the
+	// server accepted it
+	ZeroRTTRejected bool `json:"0rtt_rejected"`
+
+	PeerTransportParameters *QUICTransportParameters `json:"peer_transport_parameters,omitempty"`
+
+	// CloseReason is only set if the connection was closed by the end of the exchange
+	CloseReason *QUICCloseReason `json:"close_reason,omitempty"`
+}
+
+// QUICTransportParameters are the transport parameters the server sent
+type QUICTransportParameters struct {
+	MaxIdleTimeout                 time.Duration `json:"max_idle_timeout"`
+	MaxUDPPayloadSize              int64         `json:"max_udp_payload_size"`
+	InitialMaxData                 int64         `json:"initial_max_data"`
+	InitialMaxStreamDataBidiLocal  int64         `json:"initial_max_stream_data_bidi_local"`
+	InitialMaxStreamDataBidiRemote int64         `json:"initial_max_stream_data_bidi_remote"`
+	InitialMaxStreamDataUni        int64         `json:"initial_max_stream_data_uni"`
+	MaxBidiStreams                 int64         `json:"max_bidi_streams"`
+	MaxUniStreams                  int64         `json:"max_uni_streams"`
+	MaxAckDelay                    time.Duration `json:"max_ack_delay"`
+	AckDelayExponent               uint8         `json:"ack_delay_exponent"`
+	ActiveConnectionIDLimit        uint64        `json:"active_connection_id_limit"`
+	DisableActiveMigration         bool          `json:"disable_active_migration"`
+
+	// MaxDatagramFrameSize is only set if the server sent the parameter of the datagram extension (RFC 9221)
+	MaxDatagramFrameSize *int64 `json:"max_datagram_frame_size,omitempty"`
+}
+
+// QUICCloseReason tells why the QUIC connection was closed, ErrorCode is an application error code if Application
+// is set and a transport error code otherwise, FrameType is the frame that caused a transport error if known
+type QUICCloseReason struct {
+	Remote      bool   `json:"remote"`
+	Application bool   `json:"application"`
+	ErrorCode   uint64 `json:"error_code"`
+	FrameType   uint64 `json:"frame_type,omitempty"`
+	Reason      string `json:"reason,omitempty"`
+	Error       string `json:"error"`
+}
+
+func newQUICConnection(info *quic.ConnectionInfo) *QUICConnection {
+	connection := &QUICConnection{
+		CongestionWindow: info.CongestionWindow,
+		BytesInFlight:    info.BytesInFlight,
+		ZeroRTTRejected:  info.Rejected0RTT,
+	}
+
+	if params := info.PeerTransportParameters; params != nil {
+		connection.PeerTransportParameters = &QUICTransportParameters{
+			MaxIdleTimeout:                 params.MaxIdleTimeout,
+			MaxUDPPayloadSize:              int64(params.MaxUDPPayloadSize),
+			InitialMaxData:                 int64(params.InitialMaxData),
+			InitialMaxStreamDataBidiLocal:  int64(params.InitialMaxStreamDataBidiLocal),
+			InitialMaxStreamDataBidiRemote: int64(params.InitialMaxStreamDataBidiRemote),
+			InitialMaxStreamDataUni:        int64(params.InitialMaxStreamDataUni),
+			MaxBidiStreams:                 int64(params.MaxBidiStreamNum),
+			MaxUniStreams:                  int64(params.MaxUniStreamNum),
+			MaxAckDelay:                    params.MaxAckDelay,
+			AckDelayExponent:               params.AckDelayExponent,
+			ActiveConnectionIDLimit:        params.ActiveConnectionIDLimit,
+			DisableActiveMigration:         params.DisableActiveMigration,
+		}
+		if params.MaxDatagramFrameSize >= 0 {
+			size := int64(params.MaxDatagramFrameSize)
+			connection.PeerTransportParameters.MaxDatagramFrameSize = &size
+		}
+	}
+
+	if reason := info.CloseReason; reason != nil {
+		connection.CloseReason = &QUICCloseReason{
+			Remote:      reason.Remote,
+			Application: reason.Application,
+			ErrorCode:   reason.ErrorCode,
+			FrameType:   reason.FrameType,
+			Reason:      reason.Reason,
+			Error:       reason.Err.Error(),
+		}
+	}
+	return connection
+}
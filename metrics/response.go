@@ -9,7 +9,9 @@ type WithResponseOrError struct {
 }
 
 func (c *WithResponseOrError) GetMetrics() *Result {
-	return fromCollector(c.collector)
+	result := fromCollector(c.collector)
+	result.Outcome = classifyOutcome(c.response, c.error, c.collector)
+	return result
 }
 
 func (c *WithResponseOrError) GetError() error {
@@ -1,19 +1,66 @@
 package metrics
 
 import (
+	"crypto/tls"
+	"net"
 	"time"
 )
 
 type Result struct {
 	collector *Collector
 
+	// BootstrapDuration is the time it took to resolve the host of the upstream, it is only set for exchanges that
+	// resolved it, BootstrapSource is the bootstrap upstream that answered or "system" for the system resolver
+	BootstrapDuration *time.Duration `json:"bootstrap_duration,omitempty"`
+	BootstrapSource   *string        `json:"bootstrap_source,omitempty"`
+
 	UDPSocketSetupDuration *time.Duration `json:"udp_socket_setup_duration,omitempty"`
 
+	// TCPFallback is set when a truncated UDP response was retried over TCP. The UDP leg is described by
+	// UDPQueryTime, UDPQuerySize, UDPResponseSize and TruncatedAfter, the time from the start of the exchange until
+	// the truncated response arrived, while TCPHandshakeDuration, QueryTime, QuerySize and ResponseSize describe the
+	// TCP leg and TotalTime covers both
+	TCPFallback     bool           `json:"tcp_fallback"`
+	UDPQueryTime    *time.Duration `json:"udp_query_time,omitempty"`
+	UDPQuerySize    *int           `json:"udp_query_size,omitempty"`
+	UDPResponseSize *int           `json:"udp_response_size,omitempty"`
+	TruncatedAfter  *time.Duration `json:"truncated_after,omitempty"`
+
 	TCPHandshakeDuration *time.Duration `json:"tcp_handshake_duration,omitempty"`
 
 	TLSHandshakeDuration *time.Duration `json:"tls_handshake_duration,omitempty"`
 	TLSVersion           *uint16        `json:"tls_version,omitempty"`
 	TLSError             *int           `json:"tls_error,omitempty"`
+	TLSAlert             *uint64        `json:"tls_alert,omitempty"`
+
+	// TLSCipherSuite, TLSNegotiatedProtocol (the ALPN) and TLSCertificate describe the established TLS connection,
+	// which may have been reused from an earlier exchange
+	TLSCipherSuite        *string         `json:"tls_cipher_suite,omitempty"`
+	TLSNegotiatedProtocol *string         `json:"tls_negotiated_protocol,omitempty"`
+	TLSCertificate        *TLSCertificate `json:"tls_certificate,omitempty"`
+
+	// TLSDidResume is set when the handshake resumed an earlier session, TLSSessionTicketReceived when the server
+	// sent a session ticket to the connection established by the exchange, TLSSessionTicketLifetime is the lifetime
+	// hint of the last ticket, it is missing if a TLS 1.2 server left it unspecified
+	TLSDidResume             bool           `json:"tls_did_resume"`
+	TLSSessionTicketReceived bool           `json:"tls_session_ticket_received"`
+	TLSSessionTicketLifetime *time.Duration `json:"tls_session_ticket_lifetime,omitempty"`
+
+	// TLSProfile is the RFC 8310 usage profile (strict or opportunistic) if one was used, TLSAuthentication tells
+	// whether authenticating the server succeeded, failed or was skipped by the opportunistic profile
+	TLSProfile        *string `json:"tls_profile,omitempty"`
+	TLSAuthentication *string `json:"tls_authentication,omitempty"`
+
+	// TLSALookupDuration is only set when the TLSA records were looked up during the exchange, DANEResult tells
+	// whether they matched the certificate chain and DANEMatchedRecord is the usage, selector and matching type
+	// of the record that matched, e.g. "3 1 1"
+	TLSALookupDuration *time.Duration `json:"tlsa_lookup_duration,omitempty"`
+	DANEResult         *string        `json:"dane_result,omitempty"`
+	DANEMatchedRecord  *string        `json:"dane_matched_record,omitempty"`
+
+	// HandshakeTimeline are the steps of the TLS or QUIC handshake ordered by their time since the start of the
+	// exchange, it is only set for exchanges that established the connection
+	HandshakeTimeline []HandshakeEvent `json:"handshake_timeline,omitempty"`
 
 	QUICHandshakeDuration  *time.Duration           `json:"quic_handshake_duration,omitempty"`
 	QUICVersion            *uint64                  `json:"quic_version,omitempty"`
@@ -22,10 +69,55 @@ type Result struct {
 	QUICError              *uint64                  `json:"quic_error,omitempty"`
 	QLogMessages           []map[string]interface{} `json:"qlog_messages,omitempty"`
 
+	// QLogFile is the path of the qlog file of the connection if the qlog is written to a directory instead of
+	// QLogMessages, QLogError tells why the qlog is incomplete or missing
+	QLogFile  *string `json:"qlog_file,omitempty"`
+	QLogError *string `json:"qlog_error,omitempty"`
+
+	// QUICStats are computed from the events of the QUIC connection, see QUICStats for what they cover
+	QUICStats *QUICStats `json:"quic_stats,omitempty"`
+
+	// QUICConnection is the state of the QUIC connection reported by quic-go at the end of the exchange
+	QUICConnection *QUICConnection `json:"quic_connection,omitempty"`
+
+	// DoQError is the DoQ application error code a stream was reset or the connection was closed with by the server,
+	// DoQErrorSource tells which of the two it was
+	DoQError       *uint64 `json:"doq_error,omitempty"`
+	DoQErrorName   *string `json:"doq_error_name,omitempty"`
+	DoQErrorSource *string `json:"doq_error_source,omitempty"`
+
+	// DNSCryptVersion is the encryption system of the certificate (1 XSalsa20Poly1305, 2 XChacha20Poly1305),
+	// DNSCryptCertFetchDuration is only set when the certificate was fetched during the exchange
+	DNSCryptVersion            *uint16        `json:"dnscrypt_version,omitempty"`
+	DNSCryptCertFetchDuration  *time.Duration `json:"dnscrypt_cert_fetch_duration,omitempty"`
+	DNSCryptEncryptionOverhead *time.Duration `json:"dnscrypt_encryption_overhead,omitempty"`
+
 	HTTPVersion *string `json:"http_version,omitempty"`
+	HTTPStatus  *int    `json:"http_status,omitempty"`
 
 	QueryTime *time.Duration `json:"query_time,omitempty"`
 
+	// QuerySize and ResponseSize are the sizes of the DNS messages on the wire, excluding transport framing
+	QuerySize    *int `json:"query_size,omitempty"`
+	ResponseSize *int `json:"response_size,omitempty"`
+
+	Outcome Outcome `json:"outcome"`
+
+	ConnectionReused bool `json:"connection_reused"`
+
+	// ConnectionRedialed is set when the connection kept from an earlier exchange had been closed by the server, e.g.
+	// after an idle timeout, and the exchange dialed a new connection instead of failing
+	ConnectionRedialed bool `json:"connection_redialed"`
+
+	// RemoteAddress is the address that served the query, or the last one tried if no connection could be
+	// established, FailedDialAttempts counts the connection attempts to other addresses that failed before
+	RemoteAddress      *string `json:"remote_address,omitempty"`
+	AddressFamily      *string `json:"address_family,omitempty"`
+	FailedDialAttempts int     `json:"failed_dial_attempts"`
+
+	Cancelled bool `json:"cancelled"`
+	TimedOut  bool `json:"timed_out"`
+
 	TotalTime *time.Duration `json:"total_time,omitempty"`
 }
 
@@ -34,12 +126,15 @@ func fromCollector(collector *Collector) *Result {
 		collector: collector,
 	}
 
+	result.transformBootstrap()
 	result.transformUDP()
 	result.transformTCP()
 	result.transformTLS()
 	result.transformQUIC()
+	result.transformHandshakeTimeline()
 	result.transformCommon()
 	result.transformHTTPS()
+	result.transformDNSCrypt()
 
 	return result
 }
@@ -48,10 +143,40 @@ func toPointer(duration time.Duration) *time.Duration {
 	return &duration
 }
 
+func addressFamily(address string) *string {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil
+	}
+	family := "ipv6"
+	if ip.To4() != nil {
+		family = "ipv4"
+	}
+	return &family
+}
+
+func (r *Result) transformBootstrap() {
+	if !r.collector.bootstrapDoneTime.IsZero() {
+		r.BootstrapDuration = toPointer(r.collector.bootstrapDoneTime.Sub(r.collector.bootstrapStartTime))
+	}
+	r.BootstrapSource = r.collector.bootstrapSource
+}
+
 func (r *Result) transformUDP() {
 	if !r.collector.udpSocketSetupDoneTime.IsZero() {
 		r.UDPSocketSetupDuration = toPointer(r.collector.udpSocketSetupDoneTime.Sub(r.collector.udpSocketSetupStartTime))
 	}
+	r.TCPFallback = r.collector.tcpFallback
+	if !r.collector.udpQueryReceiveTime.IsZero() {
+		r.UDPQueryTime = toPointer(r.collector.udpQueryReceiveTime.Sub(r.collector.udpQuerySendTime))
+		r.TruncatedAfter = toPointer(r.collector.udpQueryReceiveTime.Sub(r.collector.startTime))
+	}
+	r.UDPQuerySize = r.collector.udpQuerySize
+	r.UDPResponseSize = r.collector.udpResponseSize
 }
 
 func (r *Result) transformTCP() {
@@ -66,6 +191,29 @@ func (r *Result) transformTLS() {
 	}
 	r.TLSVersion = r.collector.tlsVersion
 	r.TLSError = (*int)(r.collector.tlsError)
+	r.TLSAlert = (*uint64)(r.collector.tlsAlert)
+
+	if state := r.collector.tlsState; state != nil {
+		cipherSuite := tls.CipherSuiteName(state.CipherSuite)
+		r.TLSCipherSuite = &cipherSuite
+		if state.NegotiatedProtocol != "" {
+			r.TLSNegotiatedProtocol = &state.NegotiatedProtocol
+		}
+		r.TLSCertificate = newTLSCertificate(state, r.collector.tlsStateTime)
+		r.TLSDidResume = state.DidResume
+	}
+
+	r.collector.ticketMutex.Lock()
+	r.TLSSessionTicketReceived = r.collector.ticketReceived
+	r.TLSSessionTicketLifetime = r.collector.ticketLifetime
+	r.collector.ticketMutex.Unlock()
+	r.TLSProfile = r.collector.tlsProfile
+	r.TLSAuthentication = (*string)(r.collector.tlsAuthentication)
+	if !r.collector.tlsaLookupDoneTime.IsZero() {
+		r.TLSALookupDuration = toPointer(r.collector.tlsaLookupDoneTime.Sub(r.collector.tlsaLookupStartTime))
+	}
+	r.DANEResult = (*string)(r.collector.daneResult)
+	r.DANEMatchedRecord = r.collector.daneRecord
 }
 
 func (r *Result) transformQUIC() {
@@ -76,12 +224,30 @@ func (r *Result) transformQUIC() {
 	r.QUICError = (*uint64)(r.collector.quicError)
 	r.QUICNegotiatedProtocol = r.collector.quicNegotiatedProtocol
 	r.QUICUsed0RTT = r.collector.quicUsed0RTT
+	r.QUICStats = r.collector.quicStats
+	if r.collector.quicConnectionInfo != nil {
+		r.QUICConnection = newQUICConnection(r.collector.quicConnectionInfo)
+	}
+
+	if r.collector.doqError != nil {
+		name := r.collector.doqError.String()
+		r.DoQError = (*uint64)(r.collector.doqError)
+		r.DoQErrorName = &name
+		r.DoQErrorSource = (*string)(r.collector.doqErrorSource)
+	}
 
+	r.collector.qLogMutex.Lock()
+	defer r.collector.qLogMutex.Unlock()
 	if len(r.collector.qLogMessages) != 0 {
 		for _, message := range r.collector.qLogMessages {
 			r.QLogMessages = append(r.QLogMessages, message)
 		}
 	}
+	r.QLogFile = r.collector.qLogFile
+	if r.collector.qLogError != nil {
+		qLogError := r.collector.qLogError.Error()
+		r.QLogError = &qLogError
+	}
 }
 
 func (r *Result) transformCommon() {
@@ -91,8 +257,28 @@ func (r *Result) transformCommon() {
 	if !r.collector.queryReceiveTime.IsZero() {
 		r.QueryTime = toPointer(r.collector.queryReceiveTime.Sub(r.collector.querySendTime))
 	}
+	r.QuerySize = r.collector.querySize
+	r.ResponseSize = r.collector.responseSize
+	r.ConnectionReused = r.collector.connectionReused
+	r.ConnectionRedialed = r.collector.connectionRedialed
+	r.RemoteAddress = r.collector.remoteAddress
+	if r.RemoteAddress != nil {
+		r.AddressFamily = addressFamily(*r.RemoteAddress)
+	}
+	r.FailedDialAttempts = r.collector.dialAttemptsFailed
+	r.Cancelled = r.collector.cancelled
+	r.TimedOut = r.collector.timedOut
 }
 
 func (r *Result) transformHTTPS() {
 	r.HTTPVersion = r.collector.httpVersion
+	r.HTTPStatus = r.collector.httpStatus
+}
+
+func (r *Result) transformDNSCrypt() {
+	if !r.collector.dnscryptCertFetchDoneTime.IsZero() {
+		r.DNSCryptCertFetchDuration = toPointer(r.collector.dnscryptCertFetchDoneTime.Sub(r.collector.dnscryptCertFetchStartTime))
+	}
+	r.DNSCryptVersion = r.collector.dnscryptVersion
+	r.DNSCryptEncryptionOverhead = r.collector.dnscryptEncryptionOverhead
 }
@@ -0,0 +1,213 @@
+package queries
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/miekg/dns"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrMalformedLine = errors.New("malformed query line")
+	ErrInvalidName   = errors.New("invalid domain name")
+	ErrUnknownType   = errors.New("unknown RR type")
+	ErrUnknownClass  = errors.New("unknown RR class")
+	ErrUnknownFlag   = errors.New("unknown query flag")
+)
+
+// ParseError describes a line of a query file that could not be parsed
+type ParseError struct {
+	Line int
+	Text string
+	Err  error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("line %d: %s: %q", e.Line, e.Err, e.Text)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// Query is a single entry of a query file
+type Query struct {
+	Name  string
+	Type  uint16
+	Class uint16
+
+	// EDNS adds an OPT record, it is implied by DNSSECOK and UDPSize
+	EDNS bool
+
+	// DNSSECOK sets the DO bit
+	DNSSECOK bool
+
+	// UDPSize is the advertised EDNS buffer size, 0 means dns.DefaultMsgSize
+	UDPSize uint16
+}
+
+// Msg builds a recursive query for q with a fresh ID
+func (q Query) Msg() *dns.Msg {
+	m := new(dns.Msg)
+	m.Id = dns.Id()
+	m.RecursionDesired = true
+	m.Question = []dns.Question{
+		{Name: q.Name, Qtype: q.Type, Qclass: q.Class},
+	}
+
+	if q.EDNS || q.DNSSECOK || q.UDPSize != 0 {
+		size := q.UDPSize
+		if size == 0 {
+			size = dns.DefaultMsgSize
+		}
+		m.SetEdns0(size, q.DNSSECOK)
+	}
+
+	return m
+}
+
+// ReadFile parses the query file at path
+func ReadFile(path string) ([]Query, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return Parse(file)
+}
+
+// Parse reads queries in the format of BIND's dnsperf and resperf: one "name [class] type" per line,
+// optionally followed by the flags +edns, +do and +bufsize=N. Empty lines and lines starting with
+// ';' or '#' are ignored.
+func Parse(r io.Reader) ([]Query, error) {
+	var queries []Query
+
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, ";") || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		query, err := parseLine(text)
+		if err != nil {
+			return nil, &ParseError{Line: line, Text: text, Err: err}
+		}
+		queries = append(queries, query)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return queries, nil
+}
+
+// ParseQuery parses a single "name [class] type [flags]" entry
+func ParseQuery(text string) (Query, error) {
+	return parseLine(strings.TrimSpace(text))
+}
+
+func parseLine(text string) (Query, error) {
+	fields := strings.Fields(text)
+
+	// flags always trail the question
+	end := len(fields)
+	for end > 0 && strings.HasPrefix(fields[end-1], "+") {
+		end--
+	}
+	question, flags := fields[:end], fields[end:]
+
+	query := Query{Class: dns.ClassINET}
+	switch len(question) {
+	case 2:
+	case 3:
+		class, err := parseClass(question[1])
+		if err != nil {
+			return query, err
+		}
+		query.Class = class
+	default:
+		return query, ErrMalformedLine
+	}
+
+	if _, ok := dns.IsDomainName(question[0]); !ok {
+		return query, ErrInvalidName
+	}
+	query.Name = dns.Fqdn(question[0])
+
+	rrType, err := parseType(question[len(question)-1])
+	if err != nil {
+		return query, err
+	}
+	query.Type = rrType
+
+	for _, flag := range flags {
+		if err := query.applyFlag(flag); err != nil {
+			return query, err
+		}
+	}
+
+	return query, nil
+}
+
+func (q *Query) applyFlag(flag string) error {
+	name, value := flag, ""
+	if i := strings.Index(flag, "="); i >= 0 {
+		name, value = flag[:i], flag[i+1:]
+	}
+
+	switch strings.ToLower(name) {
+	case "+edns":
+		q.EDNS = true
+	case "+do":
+		q.DNSSECOK = true
+	case "+bufsize":
+		size, err := strconv.ParseUint(value, 10, 16)
+		if err != nil || size == 0 {
+			return fmt.Errorf("%w: %s", ErrMalformedLine, flag)
+		}
+		q.UDPSize = uint16(size)
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownFlag, flag)
+	}
+	return nil
+}
+
+func parseType(s string) (uint16, error) {
+	s = strings.ToUpper(s)
+	if rrType, ok := dns.StringToType[s]; ok {
+		return rrType, nil
+	}
+
+	// RFC 3597 generic type notation, e.g. TYPE65
+	if strings.HasPrefix(s, "TYPE") {
+		if rrType, err := strconv.ParseUint(s[len("TYPE"):], 10, 16); err == nil {
+			return uint16(rrType), nil
+		}
+	}
+
+	return 0, fmt.Errorf("%w: %s", ErrUnknownType, s)
+}
+
+func parseClass(s string) (uint16, error) {
+	s = strings.ToUpper(s)
+	if class, ok := dns.StringToClass[s]; ok {
+		return class, nil
+	}
+
+	// RFC 3597 generic class notation, e.g. CLASS1
+	if strings.HasPrefix(s, "CLASS") {
+		if class, err := strconv.ParseUint(s[len("CLASS"):], 10, 16); err == nil {
+			return uint16(class), nil
+		}
+	}
+
+	return 0, fmt.Errorf("%w: %s", ErrUnknownClass, s)
+}
@@ -0,0 +1,104 @@
+package queries
+
+import (
+	"errors"
+	"github.com/miekg/dns"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseQuery(t *testing.T) {
+	tests := []struct {
+		text    string
+		want    Query
+		wantErr error
+	}{
+		{"example.com A", Query{Name: "example.com.", Type: dns.TypeA, Class: dns.ClassINET}, nil},
+		{"  example.com.  aaaa ", Query{Name: "example.com.", Type: dns.TypeAAAA, Class: dns.ClassINET}, nil},
+		{"example.com CH TXT", Query{Name: "example.com.", Type: dns.TypeTXT, Class: dns.ClassCHAOS}, nil},
+		{"example.com CLASS3 TYPE65", Query{Name: "example.com.", Type: 65, Class: 3}, nil},
+		{"example.com MX +edns", Query{Name: "example.com.", Type: dns.TypeMX, Class: dns.ClassINET, EDNS: true}, nil},
+		{"example.com DNSKEY +DO +bufsize=1232", Query{Name: "example.com.", Type: dns.TypeDNSKEY, Class: dns.ClassINET, DNSSECOK: true, UDPSize: 1232}, nil},
+		{"example.com", Query{}, ErrMalformedLine},
+		{"example.com IN A extra", Query{}, ErrMalformedLine},
+		{"example.com A +bufsize=0", Query{}, ErrMalformedLine},
+		{"example.com A +bufsize=65536", Query{}, ErrMalformedLine},
+		{"example.com A +tcp", Query{}, ErrUnknownFlag},
+		{"example.com NOPE", Query{}, ErrUnknownType},
+		{"example.com NOPE A", Query{}, ErrUnknownClass},
+		{"example..com A", Query{}, ErrInvalidName},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			got, err := ParseQuery(tt.text)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ParseQuery() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && got != tt.want {
+				t.Errorf("ParseQuery() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	input := `; a comment
+# another comment
+
+example.com A
+example.org IN AAAA +do
+`
+	got, err := Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	want := []Query{
+		{Name: "example.com.", Type: dns.TypeA, Class: dns.ClassINET},
+		{Name: "example.org.", Type: dns.TypeAAAA, Class: dns.ClassINET, DNSSECOK: true},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Parse() = %+v, want %+v", got, want)
+	}
+}
+
+func TestParseError(t *testing.T) {
+	_, err := Parse(strings.NewReader("example.com A\n\nexample.com BOGUS\n"))
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) {
+		t.Fatalf("Parse() error = %v, want a *ParseError", err)
+	}
+	if parseErr.Line != 3 || parseErr.Text != "example.com BOGUS" || !errors.Is(err, ErrUnknownType) {
+		t.Errorf("Parse() error = %+v", parseErr)
+	}
+}
+
+func TestQueryMsg(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    Query
+		wantEDNS bool
+		wantSize uint16
+		wantDO   bool
+	}{
+		{"plain", Query{Name: "example.com.", Type: dns.TypeA, Class: dns.ClassINET}, false, 0, false},
+		{"edns", Query{Name: "example.com.", Type: dns.TypeA, Class: dns.ClassINET, EDNS: true}, true, dns.DefaultMsgSize, false},
+		{"do implies edns", Query{Name: "example.com.", Type: dns.TypeA, Class: dns.ClassINET, DNSSECOK: true}, true, dns.DefaultMsgSize, true},
+		{"bufsize", Query{Name: "example.com.", Type: dns.TypeA, Class: dns.ClassINET, UDPSize: 1232}, true, 1232, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := tt.query.Msg()
+			if !m.RecursionDesired || len(m.Question) != 1 || m.Question[0].Name != tt.query.Name || m.Question[0].Qtype != tt.query.Type {
+				t.Errorf("Msg() = %v", m)
+			}
+			opt := m.IsEdns0()
+			if (opt != nil) != tt.wantEDNS {
+				t.Fatalf("Msg() has OPT = %v, want %v", opt != nil, tt.wantEDNS)
+			}
+			if opt != nil && (opt.UDPSize() != tt.wantSize || opt.Do() != tt.wantDO) {
+				t.Errorf("OPT size, DO = %d, %v, want %d, %v", opt.UDPSize(), opt.Do(), tt.wantSize, tt.wantDO)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package queries
+
+import (
+	"errors"
+	"github.com/miekg/dns"
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+type Options struct {
+	// Loop starts over once all queries were yielded, otherwise Next returns io.EOF
+	Loop bool
+
+	// Shuffle randomizes the order of the queries on every pass
+	Shuffle bool
+
+	// Seed makes the shuffled order reproducible, 0 seeds from the current time
+	Seed int64
+
+	// EDNS and DNSSECOK are applied to every query, like dnsperf's -e and -D flags
+	EDNS     bool
+	DNSSECOK bool
+}
+
+// Source yields the queries of a query file as messages ready for DnsClient.Exchange
+type Source struct {
+	mutex   sync.Mutex
+	queries []Query
+	options Options
+	random  *rand.Rand
+	next    int
+}
+
+func NewSource(queries []Query, options Options) (*Source, error) {
+	if len(queries) == 0 {
+		return nil, errors.New("query source requires at least one query")
+	}
+
+	seed := options.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+
+	s := &Source{
+		queries: append([]Query(nil), queries...),
+		options: options,
+		random:  rand.New(rand.NewSource(seed)),
+	}
+	for i := range s.queries {
+		s.queries[i].EDNS = s.queries[i].EDNS || options.EDNS
+		s.queries[i].DNSSECOK = s.queries[i].DNSSECOK || options.DNSSECOK
+	}
+	s.shuffle()
+
+	return s, nil
+}
+
+// OpenFile creates a Source from the query file at path
+func OpenFile(path string, options Options) (*Source, error) {
+	queries, err := ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewSource(queries, options)
+}
+
+// Next returns the next query, it is safe for concurrent use
+func (s *Source) Next() (*dns.Msg, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.next == len(s.queries) {
+		if !s.options.Loop {
+			return nil, io.EOF
+		}
+		s.next = 0
+		s.shuffle()
+	}
+
+	query := s.queries[s.next]
+	s.next++
+	return query.Msg(), nil
+}
+
+func (s *Source) shuffle() {
+	if !s.options.Shuffle {
+		return
+	}
+	s.random.Shuffle(len(s.queries), func(i, j int) {
+		s.queries[i], s.queries[j] = s.queries[j], s.queries[i]
+	})
+}
@@ -197,6 +197,11 @@ type connection struct {
 	receivedRetry       bool
 	versionNegotiated   bool
 	receivedFirstPacket bool
+	rejected0RTT        bool
+
+	// info is the snapshot returned by ConnectionInfo, it is updated by the run loop
+	infoMutex sync.Mutex
+	info      ConnectionInfo
 
 	idleTimeout  time.Duration
 	creationTime time.Time
@@ -581,6 +586,7 @@ runLoop:
 		}
 
 		s.maybeResetTimer()
+		s.updateConnectionInfo(nil)
 
 		var processedUndecryptablePacket bool
 		if len(s.undecryptablePacketsToProcess) > 0 {
@@ -695,6 +701,7 @@ runLoop:
 	}
 
 	s.handleCloseError(&closeErr)
+	s.updateConnectionInfo(closeErr.err)
 	if e := (&errCloseForRecreating{}); !errors.As(closeErr.err, &e) && s.tracer != nil {
 		s.tracer.Close()
 	}
@@ -1541,6 +1548,8 @@ func (s *connection) dropEncryptionLevel(encLevel protocol.EncryptionLevel) {
 		s.tracer.DroppedEncryptionLevel(encLevel)
 	}
 	if encLevel == protocol.Encryption0RTT {
+		// 0-RTT keys are only dropped before the handshake completes if the server rejected 0-RTT
+		s.rejected0RTT = s.perspective == protocol.PerspectiveClient
 		s.streamsMap.ResetFor0RTT()
 		if err := s.connFlowController.Reset(); err != nil {
 			s.closeLocal(err)
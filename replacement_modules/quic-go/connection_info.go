@@ -0,0 +1,118 @@
+package quic
+
+import (
+	"errors"
+	"time"
+
+	"github.com/lucas-clemente/quic-go/internal/ackhandler"
+	"github.com/lucas-clemente/quic-go/internal/qerr"
+	"github.com/lucas-clemente/quic-go/internal/wire"
+)
+
+// TransportParameters are the QUIC transport parameters of a peer.
+type TransportParameters = wire.TransportParameters
+
+// ConnectionInfo is a snapshot of the state of a QUIC connection.
+type ConnectionInfo struct {
+	// Version is the QUIC version used by the connection.
+	Version VersionNumber
+
+	// The RTT statistics of the connection, they are zero until the first RTT sample was taken.
+	MinRTT        time.Duration
+	LatestRTT     time.Duration
+	SmoothedRTT   time.Duration
+	MeanDeviation time.Duration
+
+	// CongestionWindow and BytesInFlight are the state of the congestion controller, in bytes.
+	CongestionWindow uint64
+	BytesInFlight    uint64
+
+	// HandshakeComplete is set once the handshake completed.
+	HandshakeComplete bool
+	// Used0RTT is set if the server accepted the 0-RTT data of the client.
+	// It is only known once the handshake completed.
+	Used0RTT bool
+	// Rejected0RTT is set if the client sent 0-RTT data and the server rejected it.
+	Rejected0RTT bool
+
+	// PeerTransportParameters are the transport parameters sent by the peer, nil until they were received.
+	// When resuming with 0-RTT, they are the remembered parameters until the handshake completes.
+	// They must not be modified.
+	PeerTransportParameters *TransportParameters
+
+	// CloseReason is set once the connection is closed.
+	CloseReason *CloseReason
+}
+
+// CloseReason describes why a connection was closed.
+type CloseReason struct {
+	// Err is the error the connection was closed with, e.g. a *TransportError, an *ApplicationError or an
+	// *IdleTimeoutError.
+	Err error
+	// Remote is set if the peer closed the connection.
+	Remote bool
+	// Application is set if the connection was closed with an application error code.
+	Application bool
+	// ErrorCode is the transport or application error code of the CONNECTION_CLOSE frame.
+	// It is zero for timeouts, stateless resets and failed version negotiation.
+	ErrorCode uint64
+	// FrameType is the type of the frame that caused a transport error, 0 if not known.
+	FrameType uint64
+	// Reason is the reason phrase of the CONNECTION_CLOSE frame.
+	Reason string
+}
+
+func newCloseReason(err error) *CloseReason {
+	r := &CloseReason{Err: err}
+	var (
+		transportErr   *qerr.TransportError
+		applicationErr *qerr.ApplicationError
+	)
+	switch {
+	case errors.As(err, &transportErr):
+		r.Remote = transportErr.Remote
+		r.ErrorCode = uint64(transportErr.ErrorCode)
+		r.FrameType = transportErr.FrameType
+		r.Reason = transportErr.ErrorMessage
+	case errors.As(err, &applicationErr):
+		r.Remote = applicationErr.Remote
+		r.Application = true
+		r.ErrorCode = uint64(applicationErr.ErrorCode)
+		r.Reason = applicationErr.ErrorMessage
+	}
+	return r
+}
+
+func (s *connection) ConnectionInfo() ConnectionInfo {
+	s.infoMutex.Lock()
+	info := s.info
+	s.infoMutex.Unlock()
+
+	if info.HandshakeComplete {
+		info.Used0RTT = s.cryptoStreamHandler.ConnectionState().Used0RTT
+	}
+	return info
+}
+
+// updateConnectionInfo takes a new snapshot of the connection state.
+// It must only be called from the run loop.
+func (s *connection) updateConnectionInfo(closeErr error) {
+	s.infoMutex.Lock()
+	defer s.infoMutex.Unlock()
+
+	s.info.Version = s.version
+	s.info.MinRTT = s.rttStats.MinRTT()
+	s.info.LatestRTT = s.rttStats.LatestRTT()
+	s.info.SmoothedRTT = s.rttStats.SmoothedRTT()
+	s.info.MeanDeviation = s.rttStats.MeanDeviation()
+	if stats, ok := s.sentPacketHandler.(ackhandler.CongestionStats); ok {
+		s.info.CongestionWindow = uint64(stats.GetCongestionWindow())
+		s.info.BytesInFlight = uint64(stats.GetBytesInFlight())
+	}
+	s.info.HandshakeComplete = s.handshakeComplete
+	s.info.Rejected0RTT = s.rejected0RTT
+	s.info.PeerTransportParameters = s.peerParams
+	if closeErr != nil {
+		s.info.CloseReason = newCloseReason(closeErr)
+	}
+}
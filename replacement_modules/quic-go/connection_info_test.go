@@ -0,0 +1,42 @@
+package quic
+
+import (
+	"github.com/lucas-clemente/quic-go/internal/qerr"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Connection Info", func() {
+	Context("close reasons", func() {
+		It("describes transport errors", func() {
+			err := &qerr.TransportError{
+				Remote:       true,
+				FrameType:    0x6,
+				ErrorCode:    qerr.ProtocolViolation,
+				ErrorMessage: "foobar",
+			}
+			Expect(newCloseReason(err)).To(Equal(&CloseReason{
+				Err:       err,
+				Remote:    true,
+				ErrorCode: uint64(qerr.ProtocolViolation),
+				FrameType: 0x6,
+				Reason:    "foobar",
+			}))
+		})
+
+		It("describes application errors", func() {
+			err := &qerr.ApplicationError{ErrorCode: 0x42, ErrorMessage: "foobar"}
+			Expect(newCloseReason(err)).To(Equal(&CloseReason{
+				Err:         err,
+				Application: true,
+				ErrorCode:   0x42,
+				Reason:      "foobar",
+			}))
+		})
+
+		It("keeps other errors", func() {
+			Expect(newCloseReason(qerr.ErrIdleTimeout)).To(Equal(&CloseReason{Err: qerr.ErrIdleTimeout}))
+		})
+	})
+})
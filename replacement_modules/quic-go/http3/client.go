@@ -37,7 +37,9 @@ var defaultQuicConfig = &quic.Config{
 
 type dialFunc func(ctx context.Context, addr string, tlsCfg *tls.Config, cfg *quic.Config) (quic.EarlyConnection, error)
 
-var dialAddr = quic.DialAddrEarlyContext
+var dialAddr dialFunc = func(ctx context.Context, addr string, tlsCfg *tls.Config, cfg *quic.Config) (quic.EarlyConnection, error) {
+	return quic.DialAddrEarlyContext(ctx, addr, tlsCfg, cfg, 0)
+}
 
 type roundTripperOpts struct {
 	DisableCompression bool
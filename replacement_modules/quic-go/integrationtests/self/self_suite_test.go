@@ -348,6 +348,7 @@ func (t *connTracer) ClosedConnection(error)                                   {
 func (t *connTracer) SentTransportParameters(*logging.TransportParameters)     {}
 func (t *connTracer) ReceivedTransportParameters(*logging.TransportParameters) {}
 func (t *connTracer) RestoredTransportParameters(*logging.TransportParameters) {}
+func (t *connTracer) ReceivedSessionTicket(time.Duration)                      {}
 func (t *connTracer) SentPacket(hdr *logging.ExtendedHeader, size logging.ByteCount, ack *logging.AckFrame, frames []logging.Frame) {
 }
 func (t *connTracer) ReceivedVersionNegotiationPacket(*logging.Header, []logging.VersionNumber) {}
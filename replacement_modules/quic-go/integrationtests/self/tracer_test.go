@@ -45,6 +45,7 @@ func (t *customConnTracer) ClosedConnection(error)
 func (t *customConnTracer) SentTransportParameters(*logging.TransportParameters)     {}
 func (t *customConnTracer) ReceivedTransportParameters(*logging.TransportParameters) {}
 func (t *customConnTracer) RestoredTransportParameters(*logging.TransportParameters) {}
+func (t *customConnTracer) ReceivedSessionTicket(time.Duration)                      {}
 func (t *customConnTracer) SentPacket(hdr *logging.ExtendedHeader, size logging.ByteCount, ack *logging.AckFrame, frames []logging.Frame) {
 }
 
@@ -189,6 +189,9 @@ type Connection interface {
 	// It blocks until the handshake completes.
 	// Warning: This API should not be considered stable and might change soon.
 	ConnectionState() ConnectionState
+	// ConnectionInfo returns a snapshot of the version, the recovery state, 0-RTT, the peer's transport parameters
+	// and the close reason of the connection. It does not block and can be used after the connection was closed.
+	ConnectionInfo() ConnectionInfo
 
 	// SendMessage sends a message as a datagram, as specified in RFC 9221.
 	SendMessage([]byte) error
@@ -52,6 +52,13 @@ type SentPacketHandler interface {
 	OnLossDetectionTimeout() error
 }
 
+// CongestionStats reports the state of the congestion controller.
+// It is implemented by the SentPacketHandler returned by NewAckHandler.
+type CongestionStats interface {
+	GetCongestionWindow() protocol.ByteCount
+	GetBytesInFlight() protocol.ByteCount
+}
+
 type sentPacketTracker interface {
 	GetLowestPacketNotConfirmedAcked() protocol.PacketNumber
 	ReceivedPacket(protocol.EncryptionLevel)
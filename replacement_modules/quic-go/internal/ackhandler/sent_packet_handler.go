@@ -99,6 +99,7 @@ type sentPacketHandler struct {
 var (
 	_ SentPacketHandler = &sentPacketHandler{}
 	_ sentPacketTracker = &sentPacketHandler{}
+	_ CongestionStats   = &sentPacketHandler{}
 )
 
 func newSentPacketHandler(
@@ -678,6 +679,14 @@ func (h *sentPacketHandler) GetLossDetectionTimeout() time.Time {
 	return h.alarm
 }
 
+func (h *sentPacketHandler) GetCongestionWindow() protocol.ByteCount {
+	return h.congestion.GetCongestionWindow()
+}
+
+func (h *sentPacketHandler) GetBytesInFlight() protocol.ByteCount {
+	return h.bytesInFlight
+}
+
 func (h *sentPacketHandler) PeekPacketNumber(encLevel protocol.EncryptionLevel) (protocol.PacketNumber, protocol.PacketNumberLen) {
 	pnSpace := h.getPacketNumberSpace(encLevel)
 
@@ -3,6 +3,7 @@ package handshake
 import (
 	"bytes"
 	"crypto/tls"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
@@ -365,6 +366,10 @@ func (h *cryptoSetup) HandleMessage(data []byte, encLevel protocol.EncryptionLev
 		h.onError(alertUnexpectedMessage, err.Error())
 		return false
 	}
+	if msgType == typeNewSessionTicket && h.tracer != nil && len(data) >= 8 {
+		// the ticket_lifetime follows the 4 byte message header
+		h.tracer.ReceivedSessionTicket(time.Duration(binary.BigEndian.Uint32(data[4:8])) * time.Second)
+	}
 	h.messageChan <- data
 	if encLevel == protocol.Encryption1RTT {
 		h.handlePostHandshakeMessage()
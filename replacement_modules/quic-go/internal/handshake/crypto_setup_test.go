@@ -10,6 +10,7 @@ import (
 	"math/big"
 	"time"
 
+	mocklogging "github.com/lucas-clemente/quic-go/internal/mocks/logging"
 	mocktls "github.com/lucas-clemente/quic-go/internal/mocks/tls"
 	"github.com/lucas-clemente/quic-go/internal/protocol"
 	"github.com/lucas-clemente/quic-go/internal/qerr"
@@ -674,6 +675,66 @@ var _ = Describe("Crypto Setup TLS", func() {
 				client.HandleMessage(b, protocol.Encryption1RTT)
 			})
 
+			It("reports the lifetime of session tickets to the tracer", func() {
+				cChunkChan, cInitialStream, cHandshakeStream := initStreams()
+				cRunner := NewMockHandshakeRunner(mockCtrl)
+				cRunner.EXPECT().OnReceivedParams(gomock.Any())
+				cRunner.EXPECT().OnHandshakeComplete()
+				tracer := mocklogging.NewMockConnectionTracer(mockCtrl)
+				tracer.EXPECT().UpdatedKeyFromTLS(gomock.Any(), gomock.Any()).AnyTimes()
+				client, _ := NewCryptoSetupClient(
+					cInitialStream,
+					cHandshakeStream,
+					protocol.ConnectionID{},
+					nil,
+					nil,
+					&wire.TransportParameters{},
+					cRunner,
+					clientConf,
+					false,
+					&utils.RTTStats{},
+					tracer,
+					utils.DefaultLogger.WithPrefix("client"),
+					protocol.VersionTLS,
+				)
+
+				sChunkChan, sInitialStream, sHandshakeStream := initStreams()
+				sRunner := NewMockHandshakeRunner(mockCtrl)
+				sRunner.EXPECT().OnReceivedParams(gomock.Any())
+				sRunner.EXPECT().OnHandshakeComplete()
+				var token protocol.StatelessResetToken
+				server := NewCryptoSetupServer(
+					sInitialStream,
+					sHandshakeStream,
+					protocol.ConnectionID{},
+					nil,
+					nil,
+					&wire.TransportParameters{StatelessResetToken: &token},
+					sRunner,
+					serverConf,
+					false,
+					&utils.RTTStats{},
+					nil,
+					utils.DefaultLogger.WithPrefix("server"),
+					protocol.VersionTLS,
+				)
+
+				// the ticket sent by the server after the handshake
+				tracer.EXPECT().ReceivedSessionTicket(7 * 24 * time.Hour)
+				done := make(chan struct{})
+				go func() {
+					defer GinkgoRecover()
+					handshake(client, cChunkChan, server, sChunkChan)
+					close(done)
+				}()
+				Eventually(done).Should(BeClosed())
+
+				tracer.EXPECT().ReceivedSessionTicket(time.Hour)
+				cRunner.EXPECT().OnError(gomock.Any())
+				b := append([]byte{uint8(typeNewSessionTicket), 0, 0, 10, 0, 0, 0x0e, 0x10}, []byte("foobar")...)
+				client.HandleMessage(b, protocol.Encryption1RTT)
+			})
+
 			It("uses session resumption", func() {
 				csc := mocktls.NewMockClientSessionCache(mockCtrl)
 				var state *tls.ClientSessionState
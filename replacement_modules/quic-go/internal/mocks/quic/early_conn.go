@@ -81,6 +81,20 @@ func (mr *MockEarlyConnectionMockRecorder) CloseWithError(arg0, arg1 interface{}
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CloseWithError", reflect.TypeOf((*MockEarlyConnection)(nil).CloseWithError), arg0, arg1)
 }
 
+// ConnectionInfo mocks base method.
+func (m *MockEarlyConnection) ConnectionInfo() quic.ConnectionInfo {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ConnectionInfo")
+	ret0, _ := ret[0].(quic.ConnectionInfo)
+	return ret0
+}
+
+// ConnectionInfo indicates an expected call of ConnectionInfo.
+func (mr *MockEarlyConnectionMockRecorder) ConnectionInfo() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ConnectionInfo", reflect.TypeOf((*MockEarlyConnection)(nil).ConnectionInfo))
+}
+
 // ConnectionState mocks base method.
 func (m *MockEarlyConnection) ConnectionState() quic.ConnectionState {
 	m.ctrl.T.Helper()
@@ -230,6 +230,18 @@ func (mr *MockConnectionTracerMockRecorder) ReceivedVersionNegotiationPacket(arg
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReceivedVersionNegotiationPacket", reflect.TypeOf((*MockConnectionTracer)(nil).ReceivedVersionNegotiationPacket), arg0, arg1)
 }
 
+// ReceivedSessionTicket mocks base method.
+func (m *MockConnectionTracer) ReceivedSessionTicket(arg0 time.Duration) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "ReceivedSessionTicket", arg0)
+}
+
+// ReceivedSessionTicket indicates an expected call of ReceivedSessionTicket.
+func (mr *MockConnectionTracerMockRecorder) ReceivedSessionTicket(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReceivedSessionTicket", reflect.TypeOf((*MockConnectionTracer)(nil).ReceivedSessionTicket), arg0)
+}
+
 // RestoredTransportParameters mocks base method.
 func (m *MockConnectionTracer) RestoredTransportParameters(arg0 *wire.TransportParameters) {
 	m.ctrl.T.Helper()
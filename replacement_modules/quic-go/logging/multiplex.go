@@ -98,6 +98,12 @@ func (m *connTracerMultiplexer) RestoredTransportParameters(tp *TransportParamet
 	}
 }
 
+func (m *connTracerMultiplexer) ReceivedSessionTicket(lifetime time.Duration) {
+	for _, t := range m.tracers {
+		t.ReceivedSessionTicket(lifetime)
+	}
+}
+
 func (m *connTracerMultiplexer) SentPacket(hdr *ExtendedHeader, size ByteCount, ack *AckFrame, frames []Frame) {
 	for _, t := range m.tracers {
 		t.SentPacket(hdr, size, ack, frames)
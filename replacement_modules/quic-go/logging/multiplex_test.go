@@ -139,6 +139,12 @@ var _ = Describe("Tracing", func() {
 			tracer.RestoredTransportParameters(tp)
 		})
 
+		It("traces the ReceivedSessionTicket event", func() {
+			tr1.EXPECT().ReceivedSessionTicket(time.Hour)
+			tr2.EXPECT().ReceivedSessionTicket(time.Hour)
+			tracer.ReceivedSessionTicket(time.Hour)
+		})
+
 		It("traces the SentPacket event", func() {
 			hdr := &ExtendedHeader{Header: Header{DestConnectionID: ConnectionID{1, 2, 3}}}
 			ack := &AckFrame{AckRanges: []AckRange{{Smallest: 1, Largest: 10}}}
@@ -80,6 +80,20 @@ func (mr *MockQuicConnMockRecorder) CloseWithError(arg0, arg1 interface{}) *gomo
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CloseWithError", reflect.TypeOf((*MockQuicConn)(nil).CloseWithError), arg0, arg1)
 }
 
+// ConnectionInfo mocks base method.
+func (m *MockQuicConn) ConnectionInfo() ConnectionInfo {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ConnectionInfo")
+	ret0, _ := ret[0].(ConnectionInfo)
+	return ret0
+}
+
+// ConnectionInfo indicates an expected call of ConnectionInfo.
+func (mr *MockQuicConnMockRecorder) ConnectionInfo() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ConnectionInfo", reflect.TypeOf((*MockQuicConn)(nil).ConnectionInfo))
+}
+
 // ConnectionState mocks base method.
 func (m *MockQuicConn) ConnectionState() ConnectionState {
 	m.ctrl.T.Helper()
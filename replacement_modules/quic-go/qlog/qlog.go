@@ -229,6 +229,9 @@ func (t *connectionTracer) RestoredTransportParameters(tp *wire.TransportParamet
 	t.mutex.Unlock()
 }
 
+// ReceivedSessionTicket is not recorded, qlog has no event for session tickets
+func (t *connectionTracer) ReceivedSessionTicket(time.Duration) {}
+
 func (t *connectionTracer) recordTransportParameters(sentBy protocol.Perspective, tp *wire.TransportParameters) {
 	ev := t.toTransportParameters(tp)
 	ev.Owner = ownerLocal
@@ -1,5 +1,7 @@
 package terr
 
+import "fmt"
+
 type ErrorCode uint64
 
 const (
@@ -26,3 +28,54 @@ const (
 	NoRenogiation        ErrorCode = 100
 	UnsupportedExt       ErrorCode = 110
 )
+
+func (e ErrorCode) String() string {
+	switch e {
+	case UnexpectedMessage:
+		return "unexpected_message"
+	case BadRecordMac:
+		return "bad_record_mac"
+	case DecryptionFailed:
+		return "decryption_failed"
+	case RecordOverflow:
+		return "record_overflow"
+	case DecompressionFail:
+		return "decompression_failure"
+	case HandshakeFailure:
+		return "handshake_failure"
+	case BadCertificate:
+		return "bad_certificate"
+	case UnsupportedCert:
+		return "unsupported_certificate"
+	case CertificateRevoked:
+		return "certificate_revoked"
+	case CertificateExpired:
+		return "certificate_expired"
+	case CertificateUnknown:
+		return "certificate_unknown"
+	case UnknownCA:
+		return "unknown_ca"
+	case AccessDenied:
+		return "access_denied"
+	case DecodeError:
+		return "decode_error"
+	case DecryptError:
+		return "decrypt_error"
+	case ExportRestriction:
+		return "export_restriction"
+	case ProtocolVersion:
+		return "protocol_version"
+	case InsufficientSecurity:
+		return "insufficient_security"
+	case InternalError:
+		return "internal_error"
+	case UserCancelled:
+		return "user_canceled"
+	case NoRenogiation:
+		return "no_renegotiation"
+	case UnsupportedExt:
+		return "unsupported_extension"
+	default:
+		return fmt.Sprintf("unknown alert: %d", uint64(e))
+	}
+}